@@ -6,19 +6,22 @@ package exec
 
 import (
 	"bytes"
-	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/juju/clock"
 	"github.com/juju/errors"
 	"github.com/juju/loggo/v2"
+
+	"github.com/juju/utils/v4"
 )
 
 var logger = loggo.GetLogger("juju.util.exec")
@@ -27,8 +30,7 @@ var logger = loggo.GetLogger("juju.util.exec")
 // executed using bash or PowerShell.  If WorkingDir is set, this is passed
 // through.  Similarly if the Environment is specified, this is used
 // for executing the command.
-// TODO: refactor this to use a config struct and a constructor. Remove todo
-// and extra code from WaitWithCancel once this is done.
+// New code should use Run, which is configured with a Config.
 type RunParams struct {
 	Commands    string
 	WorkingDir  string
@@ -37,10 +39,56 @@ type RunParams struct {
 	KillProcess func(*os.Process) error
 	User        string
 
-	tempDir string
-	stdout  *bytes.Buffer
-	stderr  *bytes.Buffer
-	ps      *exec.Cmd
+	// Shell is the shell used to run the commands.
+	Shell Shell
+
+	// Interpreter, if set, holds the command and arguments used to
+	// run the commands instead of Shell. ScriptPlaceholder in the
+	// arguments is replaced by the path of a file holding the
+	// commands, which is otherwise passed as the last argument.
+	Interpreter []string
+
+	// UserLogin, if true, runs the commands as User through a login
+	// shell with su or runuser. Otherwise when running as root the
+	// user and groups are set directly, keeping the environment but
+	// for HOME, USER and LOGNAME, and su is only used if the user
+	// cannot be looked up.
+	UserLogin bool
+
+	// Limits holds resource limits and scheduling
+	// settings for the commands.
+	Limits Limits
+
+	// Stdout and Stderr, if not nil, receive the output of the
+	// commands as it is written, in addition to it being returned
+	// in the ExecResponse. A slow writer delays the commands.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// MaxOutputSize, if positive, is the maximum number of bytes
+	// of each of stdout and stderr returned in the ExecResponse.
+	// Further output is still written to Stdout and Stderr.
+	MaxOutputSize int
+
+	// Stdin, if not nil, is read as the input of the commands.
+	Stdin io.Reader
+
+	// PTY, if true, runs the commands in a new pseudo-terminal, of
+	// WindowSize if it is set. Stdin is copied to the terminal, followed
+	// by the end of input, and everything the terminal displays,
+	// including echoed input, is returned as stdout. Pseudo-terminals
+	// are only supported on Linux.
+	PTY        bool
+	WindowSize WindowSize
+
+	tempDir     string
+	stdout      *bytes.Buffer
+	stderr      *bytes.Buffer
+	ps          *exec.Cmd
+	defaultKill bool
+	pty         *os.File
+	ptyDone     chan struct{}
+	stdin       *os.File
 }
 
 // ExecResponse contains the return code and output generated by executing a
@@ -49,8 +97,37 @@ type ExecResponse struct {
 	Code   int
 	Stdout []byte
 	Stderr []byte
+
+	// Reason records why the process ended.
+	Reason ExitReason
+
+	// Signal holds the signal that terminated the
+	// process, if Reason is Signalled.
+	Signal os.Signal
 }
 
+// ExitReason describes why a process ended.
+type ExitReason string
+
+const (
+	// Exited means that the process exited by itself,
+	// with the status held in Code.
+	Exited ExitReason = "exited"
+
+	// Signalled means that the process was
+	// terminated by the signal held in Signal.
+	Signalled ExitReason = "signalled"
+
+	// TimedOut means that the process was killed by Run
+	// because it did not complete within the configured timeout.
+	TimedOut ExitReason = "timed out"
+
+	// Cancelled means that the process was killed because
+	// it was cancelled, by its context for Run or through
+	// the cancel channel for WaitWithCancel.
+	Cancelled ExitReason = "cancelled"
+)
+
 // mergeEnvironment takes in a string array representing the desired environment
 // and merges it with the current environment. On Windows, clearing the environment,
 // or having missing environment variables, may lead to standard go packages not working
@@ -79,17 +156,113 @@ func mergeEnvironment(env []string) []string {
 	return tmpEnv
 }
 
+// Shell identifies the shell used to run commands.
+type Shell string
+
+const (
+	// DefaultShell runs commands with bash, or with sh on hosts
+	// without bash, or with PowerShell on Windows.
+	DefaultShell Shell = ""
+
+	// Bash runs commands with /bin/bash.
+	Bash Shell = "bash"
+
+	// Sh runs commands with the POSIX /bin/sh.
+	Sh Shell = "sh"
+
+	// PowerShell runs commands with powershell.exe
+	// on Windows, or pwsh elsewhere.
+	PowerShell Shell = "powershell"
+
+	// Python3 runs commands as a python3 script.
+	Python3 Shell = "python3"
+)
+
+// Validate returns an error if the shell is not known.
+func (s Shell) Validate() error {
+	switch s {
+	case DefaultShell, Bash, Sh, PowerShell, Python3:
+		return nil
+	}
+	return errors.NotValidf("shell %q", string(s))
+}
+
+// ScriptPlaceholder is replaced by the path of the script
+// holding the commands in the arguments of an Interpreter.
+const ScriptPlaceholder = "{script}"
+
+// validateInterpreter returns an error if the interpreter cannot
+// be used, or is set along with a shell.
+func validateInterpreter(interpreter []string, shell Shell) error {
+	if interpreter == nil {
+		return nil
+	}
+	if len(interpreter) == 0 || interpreter[0] == "" {
+		return errors.NotValidf("empty Interpreter")
+	}
+	if shell != DefaultShell {
+		return errors.NotValidf("both Shell and Interpreter")
+	}
+	return nil
+}
+
+// bashPath holds the path of bash, which is run
+// by DefaultShell if it exists.
+var bashPath = "/bin/bash"
+
+// runuserPaths holds the places runuser, which switches user
+// like su but without authentication, may be installed.
+var runuserPaths = []string{"/sbin/runuser", "/usr/sbin/runuser"}
+
+// scriptRunner describes how shellAndArgs runs a script.
+type scriptRunner struct {
+	// shell is the shell used to run the script,
+	// if interpreter is not set.
+	shell Shell
+
+	// interpreter holds the command and arguments of
+	// the interpreter used to run the script, as
+	// described by RunParams.Interpreter.
+	interpreter []string
+
+	// user, if set, is the user the script is run as.
+	user string
+
+	// su, if true, runs the script as the user through a login
+	// shell. Otherwise the caller is responsible for switching to
+	// the user.
+	su bool
+}
+
 // shellAndArgs returns the name of the shell command and arguments to run the
 // specified script. shellAndArgs may write into the provided temporary
 // directory, which will be maintained until the process exits.
-func shellAndArgs(tempDir, script, user string) (string, []string, error) {
+func shellAndArgs(tempDir, script string, runner scriptRunner) (string, []string, error) {
+	if err := validateInterpreter(runner.interpreter, runner.shell); err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	shell := runner.shell
+	if shell == DefaultShell {
+		shell = Bash
+		if runtime.GOOS == "windows" {
+			shell = PowerShell
+		} else if _, err := os.Stat(bashPath); err != nil {
+			shell = Sh
+		}
+	}
 	var scriptFile string
 	var cmd string
 	var args []string
-	switch runtime.GOOS {
-	case "windows":
+	switch {
+	case runner.interpreter != nil:
+		scriptFile = filepath.Join(tempDir, "script")
+		cmd, args = interpreterArgs(runner.interpreter, scriptFile)
+	case shell == PowerShell:
 		scriptFile = filepath.Join(tempDir, "script.ps1")
-		cmd = "powershell.exe"
+		cmd = "pwsh"
+		if runtime.GOOS == "windows" {
+			cmd = "powershell.exe"
+		}
 		args = []string{
 			"-NoProfile",
 			"-NonInteractive",
@@ -101,19 +274,30 @@ func shellAndArgs(tempDir, script, user string) (string, []string, error) {
 		// using -Command is ignored and results in an exit code of 1.
 		// We use -File and trap exceptions to cover both.
 		script = "trap {Write-Error $_; exit 1}\n" + script
-	default:
+	case shell == Bash, shell == Sh:
+		if runtime.GOOS == "windows" {
+			return "", nil, errors.NotSupportedf("shell %q on windows", string(shell))
+		}
 		scriptFile = filepath.Join(tempDir, "script.sh")
-		if user == "" {
-			cmd = "/bin/bash"
-			args = []string{scriptFile}
-		} else {
-			// Need to make the tempDir readable by all so the user can see it.
-			err := os.Chmod(tempDir, 0755)
-			if err != nil {
-				return "", nil, errors.Annotatef(err, "making tempdir readable by %q", user)
-			}
-			cmd = "/bin/su"
-			args = []string{user, "--login", "--command", fmt.Sprintf("/bin/bash %s", scriptFile)}
+		cmd = "/bin/" + string(shell)
+		args = []string{scriptFile}
+	case shell == Python3:
+		scriptFile = filepath.Join(tempDir, "script.py")
+		cmd = "python3"
+		args = []string{scriptFile}
+	default:
+		return "", nil, errors.NotValidf("shell %q", string(shell))
+	}
+	if runner.user != "" && runtime.GOOS != "windows" {
+		// Need to make the tempDir readable by all so the user can see it.
+		err := os.Chmod(tempDir, 0755)
+		if err != nil {
+			return "", nil, errors.Annotatef(err, "making tempdir readable by %q", runner.user)
+		}
+		if runner.su {
+			command := quoteArgs(append([]string{cmd}, args...))
+			cmd = suCommand()
+			args = []string{runner.user, "--login", "--command", command}
 		}
 	}
 	err := ioutil.WriteFile(scriptFile, []byte(script), 0644)
@@ -123,9 +307,62 @@ func shellAndArgs(tempDir, script, user string) (string, []string, error) {
 	return cmd, args, nil
 }
 
+// interpreterArgs returns the command and arguments of the interpreter,
+// with ScriptPlaceholder replaced by scriptFile. If the placeholder is not
+// used, scriptFile is passed as the last argument.
+func interpreterArgs(interpreter []string, scriptFile string) (string, []string) {
+	var args []string
+	replaced := false
+	for _, arg := range interpreter[1:] {
+		if strings.Contains(arg, ScriptPlaceholder) {
+			arg = strings.Replace(arg, ScriptPlaceholder, scriptFile, -1)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, scriptFile)
+	}
+	return interpreter[0], args
+}
+
+// suCommand returns the command used to run a script as another user,
+// which is runuser if it is available to root, or su otherwise.
+func suCommand() string {
+	if os.Geteuid() == 0 {
+		for _, path := range runuserPaths {
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	return "/bin/su"
+}
+
+// quoteArgs returns the arguments as a command for a POSIX shell,
+// quoting any that contain characters special to the shell.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.IndexFunc(arg, needsQuote) >= 0 {
+			arg = utils.ShQuote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+func needsQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("_-+=/.,:@%", r)
+}
+
 // Run sets up the command environment (environment variables, working dir)
-// and starts the process. The commands are passed into bash on Linux machines
-// and to powershell on Windows machines.
+// and starts the process. By default the commands are passed into bash on
+// Linux machines and to powershell on Windows machines.
 func (r *RunParams) Run() error {
 	if runtime.GOOS == "windows" {
 		r.Environment = mergeEnvironment(r.Environment)
@@ -136,13 +373,38 @@ func (r *RunParams) Run() error {
 		return err
 	}
 
-	shell, args, err := shellAndArgs(tempDir, r.Commands, r.User)
+	var user *userCredential
+	if r.User != "" && !r.UserLogin {
+		user, err = lookupUser(r.User)
+		if err != nil {
+			logger.Debugf("running as %q with su: %v", r.User, err)
+		}
+	}
+	shell, args, err := shellAndArgs(tempDir, r.Commands, scriptRunner{
+		shell:       r.Shell,
+		interpreter: r.Interpreter,
+		user:        r.User,
+		su:          user == nil,
+	})
 	if err != nil {
 		if err := os.RemoveAll(tempDir); err != nil {
 			logger.Warningf("failed to remove temporary directory: %v", err)
 		}
 		return err
 	}
+	if !r.Limits.isZero() {
+		err := r.Limits.Validate()
+		if err == nil {
+			err = checkLimits(r.Limits)
+		}
+		if err != nil {
+			if err := os.RemoveAll(tempDir); err != nil {
+				logger.Warningf("failed to remove temporary directory: %v", err)
+			}
+			return errors.Trace(err)
+		}
+		shell, args = limitCommand(r.Limits, shell, args)
+	}
 
 	r.ps = exec.Command(shell, args...)
 	if r.Environment != nil {
@@ -153,21 +415,208 @@ func (r *RunParams) Run() error {
 	}
 
 	r.populateSysProcAttr()
+	if user != nil {
+		r.setUser(user)
+	}
+	cgroup, err := r.setCgroup()
+	if err != nil {
+		if err := os.RemoveAll(tempDir); err != nil {
+			logger.Warningf("failed to remove temporary directory: %v", err)
+		}
+		return errors.Trace(err)
+	}
+	if cgroup != nil {
+		defer cgroup.Close()
+	}
 
 	// If there is no user provided KillProcess function we
 	// use the default one.
 	if r.KillProcess == nil {
 		r.KillProcess = KillProcess
+		r.defaultKill = true
 	}
 
 	r.tempDir = tempDir
 	r.stdout = &bytes.Buffer{}
 	r.stderr = &bytes.Buffer{}
 
-	r.ps.Stdout = r.stdout
-	r.ps.Stderr = r.stderr
+	stdout, stderr := r.Stdout, r.Stderr
+	if stdout != nil && stderr != nil {
+		// The streams are copied concurrently, so writes to
+		// the sinks are serialised in case they are shared.
+		var mu sync.Mutex
+		stdout = &lockedWriter{mu: &mu, w: stdout}
+		stderr = &lockedWriter{mu: &mu, w: stderr}
+	}
+	var ptySlave *os.File
+	r.pty = nil
+	r.stdin = nil
+	if r.PTY {
+		ptySlave, err = r.setupPTY()
+		if err != nil {
+			if err := os.RemoveAll(tempDir); err != nil {
+				logger.Warningf("failed to remove temporary directory: %v", err)
+			}
+			return errors.Trace(err)
+		}
+		// The slave is kept open only by the process, and
+		// anything it starts, once the process has started.
+		defer ptySlave.Close()
+	} else {
+		stdin, err := r.pipeStdin()
+		if err != nil {
+			if err := os.RemoveAll(tempDir); err != nil {
+				logger.Warningf("failed to remove temporary directory: %v", err)
+			}
+			return errors.Trace(err)
+		}
+		if stdin != nil {
+			defer stdin.Close()
+		}
+		r.ps.Stdout = outputWriter(r.stdout, stdout, r.MaxOutputSize)
+		r.ps.Stderr = outputWriter(r.stderr, stderr, r.MaxOutputSize)
+	}
 
-	return r.ps.Start()
+	// The commands wait for release to be closed before
+	// running, so that their priority can be set first.
+	var release *os.File
+	if r.Limits.hasPriority() {
+		wait, w, err := os.Pipe()
+		if err != nil {
+			if r.pty != nil {
+				r.pty.Close()
+				r.pty = nil
+			}
+			if r.stdin != nil {
+				r.stdin.Close()
+				r.stdin = nil
+			}
+			if err := os.RemoveAll(tempDir); err != nil {
+				logger.Warningf("failed to remove temporary directory: %v", err)
+			}
+			return errors.Trace(err)
+		}
+		defer wait.Close()
+		r.ps.ExtraFiles = []*os.File{wait}
+		release = w
+	}
+
+	if err := r.ps.Start(); err != nil {
+		if release != nil {
+			release.Close()
+		}
+		if r.stdin != nil {
+			r.stdin.Close()
+			r.stdin = nil
+		}
+		if r.pty != nil {
+			r.pty.Close()
+			r.pty = nil
+		}
+		return err
+	}
+	if r.pty != nil {
+		r.copyPTY(outputWriter(r.stdout, stdout, r.MaxOutputSize))
+	}
+	if r.stdin != nil {
+		go func(w *os.File) {
+			if _, err := io.Copy(w, r.Stdin); err != nil {
+				logger.Debugf("copying stdin: %v", err)
+			}
+			w.Close()
+		}(r.stdin)
+	}
+	if release != nil {
+		err := setPriority(r.Limits, r.ps.Process)
+		if err != nil {
+			// The commands must not run without the
+			// requested priority.
+			if err := forceKillProcess(r.ps.Process); err != nil {
+				logger.Warningf("failed to kill process: %v", err)
+			}
+		}
+		release.Close()
+		if err != nil {
+			_, _ = r.Wait()
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// pipeStdin arranges for the process to read Stdin. Unless it is a file,
+// Stdin is copied to the process through a pipe, which is closed by Wait
+// once the process has exited. Otherwise exec.Cmd.Wait would also wait
+// for Stdin to be exhausted, even after the process had been killed.
+// The returned read end of the pipe must be closed once the process
+// has started.
+func (r *RunParams) pipeStdin() (*os.File, error) {
+	r.stdin = nil
+	if r.Stdin == nil {
+		return nil, nil
+	}
+	if f, ok := r.Stdin.(*os.File); ok {
+		r.ps.Stdin = f
+		return nil, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.ps.Stdin = pr
+	r.stdin = pw
+	return pr, nil
+}
+
+// outputWriter returns a writer that records output in buf, limited
+// to max bytes if max is positive, and also copies it to w if it is
+// not nil.
+func outputWriter(buf *bytes.Buffer, w io.Writer, max int) io.Writer {
+	var kept io.Writer = buf
+	if max > 0 {
+		kept = &limitWriter{w: buf, n: max}
+	}
+	if w == nil {
+		return kept
+	}
+	return io.MultiWriter(kept, w)
+}
+
+// limitWriter writes at most n bytes to w,
+// silently discarding anything further.
+type limitWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write implements io.Writer.
+func (w *limitWriter) Write(data []byte) (int, error) {
+	if w.n <= 0 {
+		return len(data), nil
+	}
+	kept := data
+	if len(kept) > w.n {
+		kept = kept[:w.n]
+	}
+	n, err := w.w.Write(kept)
+	w.n -= n
+	if err != nil {
+		return n, err
+	}
+	return len(data), nil
+}
+
+// lockedWriter writes to w while holding mu.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// Write implements io.Writer.
+func (w *lockedWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(data)
 }
 
 // Process returns the *os.Process instance of the current running process
@@ -190,6 +639,15 @@ func (r *RunParams) Wait() (*ExecResponse, error) {
 		return nil, errors.New("No process has been started yet")
 	}
 	err = r.ps.Wait()
+	if r.stdin != nil {
+		// Stop copying stdin to the process,
+		// which can no longer read it.
+		r.stdin.Close()
+	}
+	if r.pty != nil {
+		<-r.ptyDone
+		r.pty.Close()
+	}
 	if err := os.RemoveAll(r.tempDir); err != nil {
 		logger.Warningf("failed to remove temporary directory: %v", err)
 	}
@@ -198,13 +656,21 @@ func (r *RunParams) Wait() (*ExecResponse, error) {
 		Stdout: r.stdout.Bytes(),
 		Stderr: r.stderr.Bytes(),
 	}
+	if err == nil {
+		result.Reason = Exited
+	}
 
 	if ee, ok := err.(*exec.ExitError); ok && err != nil {
 		status := ee.ProcessState.Sys().(syscall.WaitStatus)
-		if status.Exited() {
+		switch {
+		case status.Exited():
 			// A non-zero return code isn't considered an error here.
 			result.Code = status.ExitStatus()
+			result.Reason = Exited
 			err = nil
+		case status.Signaled():
+			result.Reason = Signalled
+			result.Signal = status.Signal()
 		}
 		logger.Infof("run result: %v", ee)
 	}
@@ -219,6 +685,10 @@ var ErrCancelled = errors.New("command cancelled")
 // process before bailing out and returning.
 const timeWaitForKill = 30 * time.Second
 
+// timeWaitForTerminate represents the time we wait after asking the
+// default KillProcess to terminate a process before forcibly killing it.
+const timeWaitForTerminate = 5 * time.Second
+
 type resultWithError struct {
 	execResult *ExecResponse
 	err        error
@@ -228,6 +698,11 @@ type resultWithError struct {
 // cancel channel. In case a signal is sent it first tries to kill the process and
 // return ErrCancelled. If it fails at killing the process it will return anyway
 // and report the problematic PID.
+//
+// On unix the commands run in their own process group, and the default
+// KillProcess terminates the whole group, including any processes the
+// commands started. If the group has not exited after a short grace
+// period it is killed forcibly.
 func (r *RunParams) WaitWithCancel(cancel <-chan struct{}) (*ExecResponse, error) {
 	// TODO: Remove this once we make Clock a required field
 	_clock := r.Clock
@@ -252,13 +727,33 @@ func (r *RunParams) WaitWithCancel(cancel <-chan struct{}) (*ExecResponse, error
 			logger.Debugf("kill returned: %s", err)
 		}
 
+		// A user provided KillProcess is trusted to do whatever
+		// is needed, otherwise we escalate if terminating the
+		// process is not enough.
+		var forceKill <-chan time.Time
+		if r.defaultKill {
+			forceKill = _clock.After(timeWaitForTerminate)
+		}
+
 		// After we issue a kill we expect the wait above to return within timeWaitForKill.
 		// In case it doesn't we just go on and assume the process is stuck, but we don't block
-		select {
-		case resWithError := <-done:
-			return resWithError.execResult, ErrCancelled
-		case <-_clock.After(timeWaitForKill):
-			return nil, errors.Errorf("tried to kill process %v, but timed out", r.ps.Process.Pid)
+		timeout := _clock.After(timeWaitForKill)
+		for {
+			select {
+			case resWithError := <-done:
+				if resWithError.execResult != nil {
+					resWithError.execResult.Reason = Cancelled
+				}
+				return resWithError.execResult, ErrCancelled
+			case <-forceKill:
+				forceKill = nil
+				logger.Debugf("process did not terminate, killing it")
+				if err := forceKillProcess(r.ps.Process); err != nil {
+					logger.Debugf("kill returned: %s", err)
+				}
+			case <-timeout:
+				return nil, errors.Errorf("tried to kill process %v, but timed out", r.ps.Process.Pid)
+			}
 		}
 	}
 }
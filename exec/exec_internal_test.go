@@ -29,7 +29,7 @@ func (*execSuite) TestShellAndArgsNoUserSpecified(c *gc.C) {
 	c.Assert(err, jc.ErrorIsNil)
 	c.Assert(stat.Mode().Perm(), gc.Equals, os.FileMode(0700))
 
-	cmd, args, err := shellAndArgs(dir, "env", "")
+	cmd, args, err := shellAndArgs(dir, "env", scriptRunner{})
 	c.Assert(err, jc.ErrorIsNil)
 
 	scriptFile := filepath.Join(dir, "script.sh")
@@ -38,17 +38,18 @@ func (*execSuite) TestShellAndArgsNoUserSpecified(c *gc.C) {
 	c.Assert(args, jc.DeepEquals, []string{scriptFile})
 }
 
-func (*execSuite) TestShellAndArgsAsUser(c *gc.C) {
+func (s *execSuite) TestShellAndArgsAsUser(c *gc.C) {
 	if runtime.GOOS == "windows" {
 		c.Skip("non-windows only test")
 	}
+	s.PatchValue(&runuserPaths, nil)
 
 	dir := c.MkDir()
 	stat, err := os.Stat(dir)
 	c.Assert(err, jc.ErrorIsNil)
 	c.Assert(stat.Mode().Perm(), gc.Equals, os.FileMode(0700))
 
-	cmd, args, err := shellAndArgs(dir, "env", "ubuntu")
+	cmd, args, err := shellAndArgs(dir, "env", scriptRunner{user: "ubuntu", su: true})
 	c.Assert(err, jc.ErrorIsNil)
 
 	scriptFile := filepath.Join(dir, "script.sh")
@@ -66,3 +67,86 @@ func (*execSuite) TestShellAndArgsAsUser(c *gc.C) {
 	c.Assert(err, jc.ErrorIsNil)
 	c.Assert(stat.Mode().Perm(), gc.Equals, os.FileMode(0644))
 }
+
+func (s *execSuite) TestShellAndArgsRunuser(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("non-windows only test")
+	}
+	if os.Geteuid() != 0 {
+		c.Skip("runuser is only used by root")
+	}
+	runuser := filepath.Join(c.MkDir(), "runuser")
+	err := os.WriteFile(runuser, nil, 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	s.PatchValue(&runuserPaths, []string{"/no/such/runuser", runuser})
+
+	dir := c.MkDir()
+	cmd, args, err := shellAndArgs(dir, "env", scriptRunner{user: "ubuntu", su: true})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cmd, gc.Equals, runuser)
+	command := "/bin/bash " + filepath.Join(dir, "script.sh")
+	c.Assert(args, jc.DeepEquals, []string{"ubuntu", "--login", "--command", command})
+}
+
+func (s *execSuite) TestShellAndArgsNoBash(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("non-windows only test")
+	}
+	s.PatchValue(&bashPath, "/no/such/bash")
+
+	dir := c.MkDir()
+	cmd, args, err := shellAndArgs(dir, "env", scriptRunner{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cmd, gc.Equals, "/bin/sh")
+	c.Assert(args, jc.DeepEquals, []string{filepath.Join(dir, "script.sh")})
+}
+
+func (*execSuite) TestShellAndArgsInterpreter(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("non-windows only test")
+	}
+	dir := c.MkDir()
+	scriptFile := filepath.Join(dir, "script")
+	for i, test := range []struct {
+		interpreter []string
+		user        string
+		cmd         string
+		args        []string
+	}{{
+		interpreter: []string{"/usr/bin/perl"},
+		cmd:         "/usr/bin/perl",
+		args:        []string{scriptFile},
+	}, {
+		interpreter: []string{"/usr/bin/env", "node", "--script={script}", "--"},
+		cmd:         "/usr/bin/env",
+		args:        []string{"node", "--script=" + scriptFile, "--"},
+	}, {
+		interpreter: []string{"/opt/my tool/run", "-c", "print('hello')", ScriptPlaceholder},
+		user:        "ubuntu",
+		cmd:         "/bin/su",
+		args: []string{"ubuntu", "--login", "--command",
+			`'/opt/my tool/run' -c 'print('"'"'hello'"'"')' ` + scriptFile},
+	}} {
+		c.Logf("test %d: %q", i, test.interpreter)
+		cmd, args, err := shellAndArgs(dir, "env", scriptRunner{
+			interpreter: test.interpreter,
+			user:        test.user,
+			su:          true,
+		})
+		c.Assert(err, jc.ErrorIsNil)
+		if test.user != "" && cmd != "/bin/su" {
+			// runuser is used instead of su when running as root.
+			c.Assert(filepath.Base(cmd), gc.Equals, "runuser")
+			cmd = "/bin/su"
+		}
+		c.Assert(cmd, gc.Equals, test.cmd)
+		c.Assert(args, jc.DeepEquals, test.args)
+	}
+}
+
+func (*execSuite) TestShellAndArgsInvalidInterpreter(c *gc.C) {
+	_, _, err := shellAndArgs(c.MkDir(), "env", scriptRunner{interpreter: []string{}})
+	c.Assert(err, gc.ErrorMatches, "empty Interpreter not valid")
+	_, _, err = shellAndArgs(c.MkDir(), "env", scriptRunner{interpreter: []string{"perl"}, shell: Sh})
+	c.Assert(err, gc.ErrorMatches, "both Shell and Interpreter not valid")
+}
@@ -4,6 +4,16 @@
 package exec_test
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
@@ -100,3 +110,95 @@ func (*execSuite) TestExecUnknownCommand(c *gc.C) {
 	// 127 is a special bash return code meaning command not found.
 	c.Assert(result.Code, gc.Equals, 127)
 }
+
+func (*execSuite) TestStreamOutput(c *gc.C) {
+	var stdout, stderr bytes.Buffer
+	params := exec.RunParams{
+		Commands:      "echo 0123456789\necho abcdefghij >&2",
+		Stdout:        &stdout,
+		Stderr:        &stderr,
+		MaxOutputSize: 4,
+	}
+	result, err := exec.RunCommands(params)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "0123")
+	c.Assert(string(result.Stderr), gc.Equals, "abcd")
+	c.Assert(stdout.String(), gc.Equals, "0123456789\n")
+	c.Assert(stderr.String(), gc.Equals, "abcdefghij\n")
+}
+
+func (*execSuite) TestWaitWithCancelKillsProcessGroup(c *gc.C) {
+	pidFile := filepath.Join(c.MkDir(), "pid")
+	params := exec.RunParams{
+		Commands: fmt.Sprintf("sleep 100 &\necho $! > %s\nwait", pidFile),
+	}
+	err := params.Run()
+	c.Assert(err, jc.ErrorIsNil)
+	pid := readPidFile(c, pidFile)
+
+	cancel := make(chan struct{})
+	close(cancel)
+	_, err = params.WaitWithCancel(cancel)
+	c.Assert(err, gc.Equals, exec.ErrCancelled)
+	c.Assert(processRunning(pid), jc.IsFalse)
+}
+
+func (*execSuite) TestWaitWithCancelForcesKill(c *gc.C) {
+	pidFile := filepath.Join(c.MkDir(), "pid")
+	clk := testclock.NewClock(time.Now())
+	params := exec.RunParams{
+		Commands: fmt.Sprintf("trap '' TERM\nsleep 100 &\necho $! > %s\nwait", pidFile),
+		Clock:    clk,
+	}
+	err := params.Run()
+	c.Assert(err, jc.ErrorIsNil)
+	pid := readPidFile(c, pidFile)
+
+	cancel := make(chan struct{})
+	close(cancel)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := params.WaitWithCancel(cancel)
+		errc <- err
+	}()
+	err = clk.WaitAdvance(exec.TimeWaitForTerminate, testing.LongWait, 2)
+	c.Assert(err, jc.ErrorIsNil)
+	select {
+	case err := <-errc:
+		c.Assert(err, gc.Equals, exec.ErrCancelled)
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for the process to be killed")
+	}
+	c.Assert(processRunning(pid), jc.IsFalse)
+}
+
+// readPidFile waits for the file to hold a process ID, and returns it.
+func readPidFile(c *gc.C, path string) int {
+	timeout := time.After(testing.LongWait)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.HasSuffix(string(data), "\n") {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			c.Assert(err, jc.ErrorIsNil)
+			return pid
+		}
+		select {
+		case <-time.After(testing.ShortWait):
+		case <-timeout:
+			c.Fatalf("timed out waiting for %s", path)
+		}
+	}
+}
+
+// processRunning reports whether the process exists and has not
+// exited. Orphaned processes may remain as zombies if nothing
+// reaps them, so those are not considered running.
+func processRunning(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, which is in parentheses.
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
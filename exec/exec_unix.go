@@ -18,15 +18,39 @@ import (
 // and doesn't get killed by a regular process.Kill()
 // For details see https://groups.google.com/forum/#!topic/golang-nuts/XoQ3RhFBJl8
 func KillProcess(proc *os.Process) error {
+	return signalProcessGroup(proc, syscall.SIGTERM)
+}
+
+// terminateProcess sends sig to the process group led by proc.
+func terminateProcess(proc *os.Process, sig os.Signal) error {
+	return signalProcessGroup(proc, sig.(syscall.Signal))
+}
+
+// forceKillProcess kills the process group led by proc
+// with SIGKILL, which cannot be caught or ignored.
+func forceKillProcess(proc *os.Process) error {
+	return signalProcessGroup(proc, syscall.SIGKILL)
+}
+
+// signalProcessGroup sends sig to the process group of proc. If proc
+// has already exited and been reaped its group is assumed to be the
+// one it led, as RunParams starts it in its own group, so that any
+// processes it started remaining in the group are still signalled.
+func signalProcessGroup(proc *os.Process, sig syscall.Signal) error {
 	pgid, err := syscall.Getpgid(proc.Pid)
-	if err == nil {
-		return syscall.Kill(-pgid, 15) // note the minus sign
+	if err != nil {
+		pgid = proc.Pid
+	}
+	err = syscall.Kill(-pgid, sig) // note the minus sign
+	if err == syscall.ESRCH {
+		// Everything in the group has already exited.
+		return nil
 	}
-	return nil
+	return err
 }
 
-// populateSysProcAttr exists so that the method Kill on the same struct
-// can work correctly. For more information see Kill's comment.
+// populateSysProcAttr starts the process in its own process group, so
+// that KillProcess can kill it along with any processes it starts.
 func (r *RunParams) populateSysProcAttr() {
 	r.ps.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 }
@@ -16,5 +16,17 @@ func KillProcess(proc *os.Process) error {
 	return proc.Kill()
 }
 
+// terminateProcess kills the process passed in,
+// as Windows cannot send it a signal.
+func terminateProcess(proc *os.Process, sig os.Signal) error {
+	return proc.Kill()
+}
+
+// forceKillProcess kills the process passed in.
+// Processes it started are not killed.
+func forceKillProcess(proc *os.Process) error {
+	return proc.Kill()
+}
+
 // populateSysProcAttr is a noop on windows
 func (r *RunParams) populateSysProcAttr() {}
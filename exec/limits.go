@@ -0,0 +1,106 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Limits holds resource limits and scheduling settings for the
+// commands, and any processes they start. The zero value of each
+// field leaves the corresponding setting unchanged. Limits are
+// only supported on Linux. When the commands are run as another
+// user, limits set by PAM for that user may take precedence.
+type Limits struct {
+	// CPUTime limits the processor time each process may use,
+	// rounded up to a whole number of seconds.
+	CPUTime time.Duration
+
+	// AddressSpace limits the size in bytes of the
+	// virtual memory of each process.
+	AddressSpace uint64
+
+	// OpenFiles limits the number of files
+	// each process may have open.
+	OpenFiles uint64
+
+	// Nice is the scheduling priority of the processes,
+	// from -20 (highest) to 19 (lowest).
+	Nice int
+
+	// IOClass and IOPriority set the I/O scheduling of the
+	// processes, as ionice does. IOPriority is from 0 (highest)
+	// to 7 (lowest), and applies to the RealtimeIO and
+	// BestEffortIO classes.
+	IOClass    IOClass
+	IOPriority int
+
+	// Cgroup is the path of a cgroup v2 directory,
+	// such as "/sys/fs/cgroup/hooks", that the
+	// process is placed in when it is started.
+	Cgroup string
+}
+
+// IOClass is an I/O scheduling class.
+type IOClass int
+
+const (
+	// DefaultIO leaves the I/O scheduling class unchanged.
+	DefaultIO IOClass = iota
+
+	// RealtimeIO gives the processes first access to the disk.
+	RealtimeIO
+
+	// BestEffortIO is the normal I/O scheduling class.
+	BestEffortIO
+
+	// IdleIO only gives the processes access to
+	// the disk when no other process needs it.
+	IdleIO
+)
+
+// maxIOPriority is the lowest I/O priority within a class.
+const maxIOPriority = 7
+
+// Validate returns an error if the limits are not valid.
+func (l Limits) Validate() error {
+	if l.CPUTime < 0 {
+		return errors.NotValidf("negative CPUTime")
+	}
+	if l.Nice < -20 || l.Nice > 19 {
+		return errors.NotValidf("Nice %d", l.Nice)
+	}
+	if l.IOClass < DefaultIO || l.IOClass > IdleIO {
+		return errors.NotValidf("IOClass %d", l.IOClass)
+	}
+	if l.IOPriority < 0 || l.IOPriority > maxIOPriority {
+		return errors.NotValidf("IOPriority %d", l.IOPriority)
+	}
+	if l.IOPriority != 0 && l.IOClass != RealtimeIO && l.IOClass != BestEffortIO {
+		return errors.NotValidf("IOPriority without a RealtimeIO or BestEffortIO IOClass")
+	}
+	return nil
+}
+
+// isZero reports whether the limits leave every setting unchanged.
+func (l Limits) isZero() bool {
+	return l == Limits{}
+}
+
+// hasRlimits reports whether any resource limits are set.
+func (l Limits) hasRlimits() bool {
+	return l.CPUTime > 0 || l.AddressSpace > 0 || l.OpenFiles > 0
+}
+
+// hasPriority reports whether any scheduling priorities are set.
+func (l Limits) hasPriority() bool {
+	return l.Nice != 0 || l.IOClass != DefaultIO
+}
+
+// cpuSeconds returns CPUTime in whole seconds, rounded up.
+func (l Limits) cpuSeconds() uint64 {
+	return uint64((l.CPUTime + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,111 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+// The ioprio_set arguments, from linux/ioprio.h.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// checkLimits returns an error if the resource limits exceed those
+// of the current process, as only root may raise them.
+func checkLimits(l Limits) error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	for _, limit := range []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"CPUTime", syscall.RLIMIT_CPU, l.cpuSeconds()},
+		{"AddressSpace", syscall.RLIMIT_AS, l.AddressSpace},
+		{"OpenFiles", syscall.RLIMIT_NOFILE, l.OpenFiles},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(limit.resource, &current); err != nil {
+			return errors.Annotatef(err, "getting %s limit", limit.name)
+		}
+		if limit.value > current.Max {
+			return errors.Errorf("%s %d exceeds the hard limit of %d", limit.name, limit.value, current.Max)
+		}
+	}
+	return nil
+}
+
+// limitCommand returns the command and arguments that run cmd and
+// args with the resource limits set. The limits are set by a shell
+// before it executes the command, so that they apply from the start.
+// If priorities are to be set, the shell first waits for file
+// descriptor 3 to be closed, so that setPriority can be called
+// before anything else is started.
+func limitCommand(l Limits, cmd string, args []string) (string, []string) {
+	if !l.hasRlimits() && !l.hasPriority() {
+		return cmd, args
+	}
+	var steps []string
+	if l.CPUTime > 0 {
+		steps = append(steps, fmt.Sprintf("ulimit -t %d", l.cpuSeconds()))
+	}
+	if l.AddressSpace > 0 {
+		// ulimit -v takes the size in KiB.
+		steps = append(steps, fmt.Sprintf("ulimit -v %d", (l.AddressSpace+1023)/1024))
+	}
+	if l.OpenFiles > 0 {
+		steps = append(steps, fmt.Sprintf("ulimit -n %d", l.OpenFiles))
+	}
+	if l.hasPriority() {
+		steps = append(steps, "{ read _ <&3 || :; }", `exec "$@" 3<&-`)
+	} else {
+		steps = append(steps, `exec "$@"`)
+	}
+	script := strings.Join(steps, " && ")
+	return "/bin/sh", append([]string{"-c", script, "sh", cmd}, args...)
+}
+
+// setCgroup arranges for the process to be started in the cgroup, if
+// one is set. The returned file must be closed once it has started.
+func (r *RunParams) setCgroup() (*os.File, error) {
+	if r.Limits.Cgroup == "" {
+		return nil, nil
+	}
+	dir, err := os.Open(r.Limits.Cgroup)
+	if err != nil {
+		return nil, errors.Annotate(err, "opening cgroup")
+	}
+	r.ps.SysProcAttr.UseCgroupFD = true
+	r.ps.SysProcAttr.CgroupFD = int(dir.Fd())
+	return dir, nil
+}
+
+// setPriority sets the scheduling priorities of proc, which is the shell
+// run by limitCommand waiting to run the commands, which inherit them.
+func setPriority(l Limits, proc *os.Process) error {
+	if l.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, proc.Pid, l.Nice); err != nil {
+			return errors.Annotate(err, "setting nice value")
+		}
+	}
+	if l.IOClass != DefaultIO {
+		prio := uintptr(l.IOClass)<<ioprioClassShift | uintptr(l.IOPriority)
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(proc.Pid), prio)
+		if errno != 0 {
+			return errors.Annotate(errno, "setting I/O priority")
+		}
+	}
+	return nil
+}
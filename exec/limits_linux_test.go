@@ -0,0 +1,176 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/exec"
+)
+
+type limitsSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&limitsSuite{})
+
+func (*limitsSuite) TestValidate(c *gc.C) {
+	for i, test := range []struct {
+		limits exec.Limits
+		err    string
+	}{{
+		limits: exec.Limits{CPUTime: -time.Second},
+		err:    "negative CPUTime not valid",
+	}, {
+		limits: exec.Limits{Nice: 20},
+		err:    "Nice 20 not valid",
+	}, {
+		limits: exec.Limits{IOClass: 4},
+		err:    "IOClass 4 not valid",
+	}, {
+		limits: exec.Limits{IOClass: exec.BestEffortIO, IOPriority: 8},
+		err:    "IOPriority 8 not valid",
+	}, {
+		limits: exec.Limits{IOClass: exec.IdleIO, IOPriority: 1},
+		err:    "IOPriority without a RealtimeIO or BestEffortIO IOClass not valid",
+	}} {
+		c.Logf("test %d", i)
+		err := test.limits.Validate()
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+		c.Check(err, gc.ErrorMatches, test.err)
+		_, err = exec.Run(context.Background(), exec.Config{
+			Limits: test.limits,
+			Clock:  clock.WallClock,
+		})
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (*limitsSuite) TestRlimits(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "ulimit -t\nulimit -v\nulimit -n",
+		Limits: exec.Limits{
+			CPUTime:      1500 * time.Millisecond,
+			AddressSpace: 1 << 30,
+			OpenFiles:    64,
+		},
+		Clock: clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stderr), gc.Equals, "")
+	c.Assert(string(result.Stdout), gc.Equals, "2\n1048576\n64\n")
+}
+
+func (*limitsSuite) TestRlimitsApplyToChildren(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "sh -c 'ulimit -n'",
+		Limits:   exec.Limits{OpenFiles: 64},
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "64\n")
+}
+
+func (*limitsSuite) TestRlimitAboveHardLimit(c *gc.C) {
+	if os.Geteuid() == 0 {
+		c.Skip("root may raise hard limits")
+	}
+	var current syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &current)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = exec.Run(context.Background(), exec.Config{
+		Commands: "true",
+		Limits:   exec.Limits{OpenFiles: current.Max + 1},
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, gc.ErrorMatches, "OpenFiles .* exceeds the hard limit of .*")
+}
+
+func (*limitsSuite) TestNice(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "nice",
+		Limits:   exec.Limits{Nice: 5},
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "5\n")
+}
+
+func (*limitsSuite) TestIOClass(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "command -v ionice >/dev/null || exit 127\nionice -p $$",
+		Limits:   exec.Limits{IOClass: exec.BestEffortIO, IOPriority: 6},
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	if result.Code == 127 {
+		c.Skip("ionice not available")
+	}
+	c.Assert(string(result.Stdout), gc.Equals, "best-effort: prio 6\n")
+}
+
+func (s *limitsSuite) TestCgroup(c *gc.C) {
+	cgroup := s.makeCgroup(c)
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "cat /proc/self/cgroup",
+		Limits:   exec.Limits{Cgroup: cgroup},
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), jc.Contains, fmt.Sprintf("0::/%s\n", filepath.Base(cgroup)))
+}
+
+func (*limitsSuite) TestCgroupNotFound(c *gc.C) {
+	_, err := exec.Run(context.Background(), exec.Config{
+		Commands: "true",
+		Limits:   exec.Limits{Cgroup: filepath.Join(c.MkDir(), "missing")},
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, gc.ErrorMatches, "opening cgroup: .*")
+}
+
+// makeCgroup creates a cgroup v2 directory that is removed when the
+// test completes, or skips the test if that is not possible.
+func (s *limitsSuite) makeCgroup(c *gc.C) string {
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		if _, err := os.Stat(filepath.Join(root, "cgroup.procs")); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil && root == "/sys/fs/cgroup" {
+			// A cgroup v1 hierarchy.
+			continue
+		}
+		dir := filepath.Join(root, fmt.Sprintf("juju-exec-test-%d", os.Getpid()))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			c.Skip(fmt.Sprintf("cannot create cgroup: %v", err))
+		}
+		s.AddCleanup(func(c *gc.C) {
+			// The cgroup can only be removed once its
+			// processes have been reaped.
+			deadline := time.Now().Add(testing.LongWait)
+			for {
+				err := os.Remove(dir)
+				if err == nil || !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+					c.Check(err, jc.ErrorIsNil)
+					return
+				}
+				time.Sleep(testing.ShortWait)
+			}
+		})
+		return dir
+	}
+	c.Skip("cgroup v2 not available")
+	return ""
+}
@@ -0,0 +1,36 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !linux
+// +build !linux
+
+package exec
+
+import (
+	"os"
+
+	"github.com/juju/errors"
+)
+
+// checkLimits returns an error, as limits are only supported on Linux.
+func checkLimits(l Limits) error {
+	return errors.NotSupportedf("resource limits on this platform")
+}
+
+// limitCommand is never called on this platform, as checkLimits
+// always fails.
+func limitCommand(l Limits, cmd string, args []string) (string, []string) {
+	return cmd, args
+}
+
+// setPriority is never called on this platform, as checkLimits
+// always fails.
+func setPriority(l Limits, proc *os.Process) error {
+	return nil
+}
+
+// setCgroup is never called on this platform, as checkLimits
+// always fails.
+func (r *RunParams) setCgroup() (*os.File, error) {
+	return nil, nil
+}
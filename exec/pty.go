@@ -0,0 +1,28 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec
+
+import (
+	"github.com/juju/errors"
+)
+
+// WindowSize is the size of a terminal in characters.
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// defaultWindowSize is the size of the pseudo-terminal
+// used if RunParams does not specify one.
+var defaultWindowSize = WindowSize{Rows: 24, Cols: 80}
+
+// SetWindowSize changes the size of the pseudo-terminal that
+// the commands are running in, which must have been started
+// with PTY set.
+func (r *RunParams) SetWindowSize(size WindowSize) error {
+	if r.pty == nil {
+		return errors.New("commands not running in a pseudo-terminal")
+	}
+	return errors.Trace(setWindowSize(r.pty, size))
+}
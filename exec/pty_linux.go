@@ -0,0 +1,145 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// eot is the character a terminal reads as the end of its input.
+const eot = 0x04
+
+// openPTY returns the master and slave
+// ends of a new pseudo-terminal.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "opening pseudo-terminal")
+	}
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		return nil, nil, errors.Annotate(err, "unlocking pseudo-terminal")
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		return nil, nil, errors.Annotate(err, "getting pseudo-terminal number")
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "opening pseudo-terminal")
+	}
+	return master, slave, nil
+}
+
+// setWindowSize sets the size of the pseudo-terminal.
+func setWindowSize(master *os.File, size WindowSize) error {
+	// The layout of struct winsize.
+	ws := [4]uint16{size.Rows, size.Cols, 0, 0}
+	return errors.Annotate(ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws)), "setting window size")
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setupPTY arranges for the process to run with a new pseudo-terminal as
+// its controlling terminal and standard input, output and error. The
+// returned slave must be closed once the process has started.
+func (r *RunParams) setupPTY() (*os.File, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	size := r.WindowSize
+	if size == (WindowSize{}) {
+		size = defaultWindowSize
+	}
+	if err := setWindowSize(master, size); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, errors.Trace(err)
+	}
+	r.ps.Stdin = slave
+	r.ps.Stdout = slave
+	r.ps.Stderr = slave
+	// The process leads a new session, and so its own process
+	// group, with the pseudo-terminal (its stdin) controlling it.
+	r.ps.SysProcAttr.Setpgid = false
+	r.ps.SysProcAttr.Setsid = true
+	r.ps.SysProcAttr.Setctty = true
+	r.ps.SysProcAttr.Ctty = 0
+	r.pty = master
+	return slave, nil
+}
+
+// copyPTY copies the output of the pseudo-terminal to output until
+// every process using it has closed it, and copies Stdin, if set, to
+// it, followed by the end of input.
+func (r *RunParams) copyPTY(output io.Writer) {
+	r.ptyDone = make(chan struct{})
+	go func() {
+		defer close(r.ptyDone)
+		// Reading fails with EIO once the terminal is closed.
+		_, _ = io.Copy(output, r.pty)
+	}()
+	if r.Stdin != nil {
+		go func() {
+			w := &lastByteWriter{w: r.pty}
+			if _, err := io.Copy(w, r.Stdin); err != nil {
+				logger.Debugf("copying stdin to pseudo-terminal: %v", err)
+				return
+			}
+			// The terminal only reads EOT as the end of input at the
+			// start of a line. Elsewhere it just passes on the partial
+			// line, so a second EOT is needed.
+			end := []byte{eot}
+			if w.written && w.last != '\n' {
+				end = append(end, eot)
+			}
+			_, _ = r.pty.Write(end)
+		}()
+	}
+}
+
+// lastByteWriter writes to w, recording the last byte written.
+type lastByteWriter struct {
+	w       io.Writer
+	last    byte
+	written bool
+}
+
+// Write implements io.Writer.
+func (w *lastByteWriter) Write(data []byte) (int, error) {
+	n, err := w.w.Write(data)
+	if n > 0 {
+		w.last = data[n-1]
+		w.written = true
+	}
+	return n, err
+}
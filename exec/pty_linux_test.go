@@ -0,0 +1,181 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	stdtesting "testing"
+	"time"
+	"unsafe"
+
+	"github.com/juju/clock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/exec"
+)
+
+// ttyHelperEnv is set to run the test binary as a helper
+// program that reports on its terminal, then echoes its input.
+const ttyHelperEnv = "JUJU_EXEC_TTY_HELPER"
+
+func TestMain(m *stdtesting.M) {
+	if os.Getenv(ttyHelperEnv) != "" {
+		ttyHelper()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func ttyHelper() {
+	fmt.Printf("stdin tty: %v\n", isTerminal(os.Stdin))
+	fmt.Printf("stdout tty: %v\n", isTerminal(os.Stdout))
+	var ws [4]uint16
+	if ioctl(os.Stdout, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)) == nil {
+		fmt.Printf("size: %dx%d\n", ws[0], ws[1])
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fmt.Printf("got: %s\n", scanner.Text())
+	}
+	fmt.Println("eof")
+}
+
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)) == nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// helperConfig returns a Config that runs the helper program.
+func helperConfig() exec.Config {
+	return exec.Config{
+		Commands:    `exec "$HELPER"`,
+		Environment: []string{ttyHelperEnv + "=1", "HELPER=" + os.Args[0]},
+		Clock:       clock.WallClock,
+	}
+}
+
+type ptySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&ptySuite{})
+
+func (*ptySuite) TestStdin(c *gc.C) {
+	config := helperConfig()
+	config.Stdin = strings.NewReader("hello\nworld\n")
+	result, err := exec.Run(context.Background(), config)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, `
+stdin tty: false
+stdout tty: false
+got: hello
+got: world
+eof
+`[1:])
+}
+
+func (*ptySuite) TestPTY(c *gc.C) {
+	config := helperConfig()
+	config.PTY = true
+	config.WindowSize = exec.WindowSize{Rows: 40, Cols: 132}
+	config.Stdin = strings.NewReader("hello\n")
+	result, err := exec.Run(context.Background(), config)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result.Code, gc.Equals, 0)
+	c.Assert(string(result.Stderr), gc.Equals, "")
+	// The terminal echoes the input, and translates newlines.
+	output := strings.Replace(string(result.Stdout), "\r\n", "\n", -1)
+	c.Assert(output, jc.Contains, "stdin tty: true\nstdout tty: true\nsize: 40x132\n")
+	c.Assert(output, jc.Contains, "hello\n")
+	c.Assert(output, jc.HasSuffix, "got: hello\neof\n")
+}
+
+func (*ptySuite) TestPTYStdinWithoutNewline(c *gc.C) {
+	config := helperConfig()
+	config.PTY = true
+	config.Stdin = strings.NewReader("one\ntwo")
+	result, err := exec.Run(context.Background(), config)
+	c.Assert(err, jc.ErrorIsNil)
+	output := strings.Replace(string(result.Stdout), "\r\n", "\n", -1)
+	c.Assert(output, jc.HasSuffix, "got: one\ngot: two\neof\n")
+}
+
+func (*ptySuite) TestPTYEmptyStdin(c *gc.C) {
+	config := helperConfig()
+	config.PTY = true
+	config.Stdin = strings.NewReader("")
+	result, err := exec.Run(context.Background(), config)
+	c.Assert(err, jc.ErrorIsNil)
+	output := strings.Replace(string(result.Stdout), "\r\n", "\n", -1)
+	c.Assert(output, jc.HasSuffix, "size: 24x80\neof\n")
+}
+
+func (*ptySuite) TestPTYMergesOutput(c *gc.C) {
+	var stdout bytes.Buffer
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "echo out\necho err >&2",
+		PTY:      true,
+		Stdout:   &stdout,
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "out\r\nerr\r\n")
+	c.Assert(string(result.Stderr), gc.Equals, "")
+	c.Assert(stdout.String(), gc.Equals, "out\r\nerr\r\n")
+}
+
+func (*ptySuite) TestSetWindowSize(c *gc.C) {
+	pidFile := c.MkDir() + "/pid"
+	params := exec.RunParams{
+		Commands: fmt.Sprintf("stty size\necho $$ > %s\nread line\nstty size", pidFile),
+		PTY:      true,
+	}
+	err := params.SetWindowSize(exec.WindowSize{Rows: 1, Cols: 1})
+	c.Assert(err, gc.ErrorMatches, "commands not running in a pseudo-terminal")
+
+	r, w, err := os.Pipe()
+	c.Assert(err, jc.ErrorIsNil)
+	defer r.Close()
+	params.Stdin = r
+	err = params.Run()
+	c.Assert(err, jc.ErrorIsNil)
+	readPidFile(c, pidFile)
+	err = params.SetWindowSize(exec.WindowSize{Rows: 50, Cols: 100})
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = w.Write([]byte("\n"))
+	c.Assert(err, jc.ErrorIsNil)
+	w.Close()
+
+	result, err := params.Wait()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "24 80\r\n\r\n50 100\r\n")
+}
+
+func (*ptySuite) TestPTYCancel(c *gc.C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err := exec.Run(ctx, exec.Config{
+		Commands: "sleep 100",
+		PTY:      true,
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(result.Reason, gc.Equals, exec.Cancelled)
+}
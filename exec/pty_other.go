@@ -0,0 +1,29 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !linux
+// +build !linux
+
+package exec
+
+import (
+	"io"
+	"os"
+
+	"github.com/juju/errors"
+)
+
+// setWindowSize is never called on this platform,
+// as setupPTY always fails.
+func setWindowSize(master *os.File, size WindowSize) error {
+	return errors.NotSupportedf("pseudo-terminals on this platform")
+}
+
+// setupPTY returns an error, as pseudo-terminals
+// are only supported on Linux.
+func (r *RunParams) setupPTY() (*os.File, error) {
+	return nil, errors.NotSupportedf("pseudo-terminals on this platform")
+}
+
+// copyPTY is never called on this platform, as setupPTY always fails.
+func (r *RunParams) copyPTY(output io.Writer) {}
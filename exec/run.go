@@ -0,0 +1,212 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// defaultGracePeriod is the time Run waits after sending the
+// terminate signal before killing the process, if the Config
+// does not specify one.
+const defaultGracePeriod = timeWaitForTerminate
+
+// Config holds the parameters for Run.
+type Config struct {
+	// Commands holds the commands to run.
+	Commands string
+
+	// Shell is the shell used to run the commands.
+	Shell Shell
+
+	// Interpreter, if set, holds the command and arguments used to
+	// run the commands instead of Shell, as for RunParams.
+	Interpreter []string
+
+	// WorkingDir, if set, is the directory the commands are run in.
+	WorkingDir string
+
+	// Environment, if not nil, is the environment
+	// the commands are run with.
+	Environment []string
+
+	// User, if set, is the user the commands are run as.
+	User string
+
+	// UserLogin, if true, runs the commands as User
+	// through a login shell, as for RunParams.
+	UserLogin bool
+
+	// Limits holds resource limits and scheduling
+	// settings for the commands.
+	Limits Limits
+
+	// Stdout and Stderr, if not nil, receive the output
+	// of the commands as it is written.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// MaxOutputSize, if positive, is the maximum number of bytes
+	// of each of stdout and stderr returned in the ExecResponse.
+	MaxOutputSize int
+
+	// Stdin, if not nil, is read as the input of the commands.
+	Stdin io.Reader
+
+	// PTY, if true, runs the commands in a pseudo-terminal
+	// of WindowSize, as for RunParams.
+	PTY        bool
+	WindowSize WindowSize
+
+	// Timeout, if positive, is the time after which
+	// the commands are stopped if they are still running.
+	Timeout time.Duration
+
+	// TerminateSignal is sent to the commands to stop them when
+	// they time out or are cancelled. It defaults to SIGTERM.
+	// On Windows the process is always killed without a signal.
+	TerminateSignal os.Signal
+
+	// GracePeriod is the time to wait after sending TerminateSignal
+	// before the commands are killed. It defaults to 5 seconds.
+	GracePeriod time.Duration
+
+	// Clock is used to implement Timeout and GracePeriod.
+	Clock clock.Clock
+}
+
+// Validate returns an error if the config cannot be used to run commands.
+func (config Config) Validate() error {
+	if err := config.Shell.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := validateInterpreter(config.Interpreter, config.Shell); err != nil {
+		return errors.Trace(err)
+	}
+	if err := config.Limits.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if config.Timeout < 0 {
+		return errors.NotValidf("negative Timeout")
+	}
+	if config.GracePeriod < 0 {
+		return errors.NotValidf("negative GracePeriod")
+	}
+	if config.TerminateSignal != nil {
+		if _, ok := config.TerminateSignal.(syscall.Signal); !ok {
+			return errors.NotValidf("TerminateSignal %v", config.TerminateSignal)
+		}
+	}
+	if config.Clock == nil {
+		return errors.NotValidf("nil Clock")
+	}
+	return nil
+}
+
+// Run runs the commands specified in the config and waits for them to
+// complete, returning their output and why they ended. A non-zero exit
+// status or termination by a signal is reported in the response and
+// is not an error.
+//
+// If the context is done, or the timeout expires, before the commands
+// complete, they are sent the terminate signal and then killed if they
+// have not exited at the end of the grace period. The response is
+// returned along with the context's error, or a timeout error.
+func Run(ctx context.Context, config Config) (*ExecResponse, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	terminateSignal := config.TerminateSignal
+	if terminateSignal == nil {
+		terminateSignal = syscall.SIGTERM
+	}
+	gracePeriod := config.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultGracePeriod
+	}
+
+	params := RunParams{
+		Commands:      config.Commands,
+		Shell:         config.Shell,
+		Interpreter:   config.Interpreter,
+		WorkingDir:    config.WorkingDir,
+		Environment:   config.Environment,
+		User:          config.User,
+		UserLogin:     config.UserLogin,
+		Limits:        config.Limits,
+		Stdout:        config.Stdout,
+		Stderr:        config.Stderr,
+		MaxOutputSize: config.MaxOutputSize,
+		Stdin:         config.Stdin,
+		PTY:           config.PTY,
+		WindowSize:    config.WindowSize,
+		Clock:         config.Clock,
+	}
+	if err := params.Run(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	proc := params.ps.Process
+
+	done := make(chan resultWithError, 1)
+	go func() {
+		result, err := params.Wait()
+		done <- resultWithError{result, err}
+	}()
+
+	var timeout <-chan time.Time
+	if config.Timeout > 0 {
+		timeout = config.Clock.After(config.Timeout)
+	}
+	var reason ExitReason
+	var reasonErr error
+	select {
+	case res := <-done:
+		if res.err != nil && (res.execResult == nil || res.execResult.Reason != Signalled) {
+			return nil, errors.Trace(res.err)
+		}
+		return res.execResult, nil
+	case <-ctx.Done():
+		reason, reasonErr = Cancelled, ctx.Err()
+	case <-timeout:
+		reason = TimedOut
+		reasonErr = errors.NewTimeout(nil, fmt.Sprintf("command timed out after %v", config.Timeout))
+	}
+
+	logger.Debugf("command %s, terminating process %d", reason, proc.Pid)
+	if err := terminateProcess(proc, terminateSignal); err != nil {
+		logger.Debugf("terminate returned: %s", err)
+	}
+	forceKill := config.Clock.After(gracePeriod)
+	var killTimeout <-chan time.Time
+	for {
+		select {
+		case res := <-done:
+			if res.execResult == nil {
+				return nil, errors.Trace(res.err)
+			}
+			res.execResult.Reason = reason
+			return res.execResult, reasonErr
+		case <-forceKill:
+			forceKill = nil
+			logger.Debugf("process %d did not terminate, killing it", proc.Pid)
+			if err := forceKillProcess(proc); err != nil {
+				logger.Debugf("kill returned: %s", err)
+			}
+			killTimeout = config.Clock.After(timeWaitForKill)
+		case <-killTimeout:
+			return nil, errors.Errorf("tried to kill process %v, but timed out", proc.Pid)
+		}
+	}
+}
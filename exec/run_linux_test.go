@@ -0,0 +1,283 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package exec_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/exec"
+)
+
+type runSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&runSuite{})
+
+func (*runSuite) TestValidate(c *gc.C) {
+	for i, test := range []struct {
+		about  string
+		config exec.Config
+		err    string
+	}{{
+		about:  "unknown shell",
+		config: exec.Config{Shell: "fish", Clock: clock.WallClock},
+		err:    `shell "fish" not valid`,
+	}, {
+		about:  "negative timeout",
+		config: exec.Config{Timeout: -time.Second, Clock: clock.WallClock},
+		err:    "negative Timeout not valid",
+	}, {
+		about:  "negative grace period",
+		config: exec.Config{GracePeriod: -time.Second, Clock: clock.WallClock},
+		err:    "negative GracePeriod not valid",
+	}, {
+		about:  "nil clock",
+		config: exec.Config{},
+		err:    "nil Clock not valid",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		err := test.config.Validate()
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+		c.Check(err, gc.ErrorMatches, test.err)
+		_, err = exec.Run(context.Background(), test.config)
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (*runSuite) TestRunExited(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "echo out\necho err >&2\nexit 3",
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result.Reason, gc.Equals, exec.Exited)
+	c.Assert(result.Code, gc.Equals, 3)
+	c.Assert(result.Signal, gc.IsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "out\n")
+	c.Assert(string(result.Stderr), gc.Equals, "err\n")
+}
+
+func (*runSuite) TestRunSignalled(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "kill -KILL $$",
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result.Reason, gc.Equals, exec.Signalled)
+	c.Assert(result.Signal, gc.Equals, syscall.SIGKILL)
+}
+
+func (*runSuite) TestRunShell(c *gc.C) {
+	for _, shell := range []exec.Shell{exec.DefaultShell, exec.Bash, exec.Sh} {
+		c.Logf("shell %q", shell)
+		result, err := exec.Run(context.Background(), exec.Config{
+			Commands: `echo "$0"`,
+			Shell:    shell,
+			Clock:    clock.WallClock,
+		})
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(result.Code, gc.Equals, 0)
+		c.Assert(filepath.Base(string(result.Stdout)), gc.Equals, "script.sh\n")
+	}
+}
+
+func (*runSuite) TestRunCancelled(c *gc.C) {
+	pidFile := filepath.Join(c.MkDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		readPidFile(c, pidFile)
+		cancel()
+	}()
+	result, err := exec.Run(ctx, exec.Config{
+		Commands: fmt.Sprintf("echo $$ > %s\nsleep 100", pidFile),
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, gc.Equals, context.Canceled)
+	c.Assert(result.Reason, gc.Equals, exec.Cancelled)
+}
+
+func (*runSuite) TestRunAlreadyCancelled(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := exec.Run(ctx, exec.Config{
+		Commands: "echo hello",
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, gc.Equals, context.Canceled)
+	c.Assert(result, gc.IsNil)
+}
+
+func (*runSuite) TestRunTimeout(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	errc := make(chan error, 1)
+	var result *exec.ExecResponse
+	go func() {
+		var err error
+		result, err = exec.Run(context.Background(), exec.Config{
+			Commands: "sleep 100",
+			Timeout:  time.Minute,
+			Clock:    clk,
+		})
+		errc <- err
+	}()
+	err := clk.WaitAdvance(time.Minute, testing.LongWait, 1)
+	c.Assert(err, jc.ErrorIsNil)
+	err = waitError(c, errc)
+	c.Assert(err, jc.Satisfies, errors.IsTimeout)
+	c.Assert(err, gc.ErrorMatches, "command timed out after 1m0s")
+	c.Assert(result.Reason, gc.Equals, exec.TimedOut)
+}
+
+func (*runSuite) TestRunTerminateSignal(c *gc.C) {
+	pidFile := filepath.Join(c.MkDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		readPidFile(c, pidFile)
+		cancel()
+	}()
+	result, err := exec.Run(ctx, exec.Config{
+		Commands:        fmt.Sprintf("trap 'echo interrupted; exit 3' INT\necho $$ > %s\nsleep 100", pidFile),
+		TerminateSignal: os.Interrupt,
+		Clock:           clock.WallClock,
+	})
+	c.Assert(err, gc.Equals, context.Canceled)
+	c.Assert(result.Reason, gc.Equals, exec.Cancelled)
+	c.Assert(result.Code, gc.Equals, 3)
+	c.Assert(string(result.Stdout), gc.Equals, "interrupted\n")
+}
+
+func (*runSuite) TestRunGracePeriod(c *gc.C) {
+	pidFile := filepath.Join(c.MkDir(), "pid")
+	clk := testclock.NewClock(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	var result *exec.ExecResponse
+	go func() {
+		var err error
+		result, err = exec.Run(ctx, exec.Config{
+			Commands:    fmt.Sprintf("trap '' TERM\necho $$ > %s\nsleep 100", pidFile),
+			GracePeriod: time.Minute,
+			Clock:       clk,
+		})
+		errc <- err
+	}()
+	readPidFile(c, pidFile)
+	cancel()
+	err := clk.WaitAdvance(time.Minute, testing.LongWait, 1)
+	c.Assert(err, jc.ErrorIsNil)
+	err = waitError(c, errc)
+	c.Assert(err, gc.Equals, context.Canceled)
+	c.Assert(result.Reason, gc.Equals, exec.Cancelled)
+}
+
+func waitError(c *gc.C, errc <-chan error) error {
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for the command")
+	}
+	panic("unreachable")
+}
+
+func (*runSuite) TestRunInterpreter(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands:    "echo $1 $2",
+		Interpreter: []string{"/bin/sh", exec.ScriptPlaceholder, "hello", "world"},
+		Clock:       clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "hello world\n")
+}
+
+func (s *runSuite) TestRunPython3(c *gc.C) {
+	s.PatchEnvironment("PATH", "/usr/local/bin:/usr/bin:/bin")
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "import sys\nprint('hello from', sys.version_info[0])",
+		Shell:    exec.Python3,
+		Clock:    clock.WallClock,
+	})
+	if errors.Is(err, osexec.ErrNotFound) {
+		c.Skip("python3 not available")
+	}
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "hello from 3\n")
+}
+
+func (*runSuite) TestRunAsUser(c *gc.C) {
+	if os.Geteuid() != 0 {
+		c.Skip("running as another user requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		c.Skip("user nobody not available")
+	}
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands:    "id -u\necho $USER $HOME $KEPT",
+		User:        "nobody",
+		Environment: []string{"KEPT=kept", "USER=root", "PATH=/usr/bin:/bin"},
+		Clock:       clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stderr), gc.Equals, "")
+	c.Assert(string(result.Stdout), gc.Equals, fmt.Sprintf("%s\nnobody %s kept\n", nobody.Uid, nobody.HomeDir))
+}
+
+func (*runSuite) TestRunStdin(c *gc.C) {
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "cat",
+		Stdin:    strings.NewReader("hello\nworld"),
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "hello\nworld")
+}
+
+func (*runSuite) TestRunCancelledWithBlockedStdin(c *gc.C) {
+	// Reading stdin never completes, which must not
+	// stop Run returning once the process is killed.
+	stdin, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err := exec.Run(ctx, exec.Config{
+		Commands: "sleep 100",
+		Stdin:    stdin,
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(result.Reason, gc.Equals, exec.Cancelled)
+}
+
+func (*runSuite) TestRunExitsWithBlockedStdin(c *gc.C) {
+	stdin, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	result, err := exec.Run(context.Background(), exec.Config{
+		Commands: "echo done",
+		Stdin:    stdin,
+		Clock:    clock.WallClock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(result.Stdout), gc.Equals, "done\n")
+}
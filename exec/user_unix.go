@@ -0,0 +1,90 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+// userCredential holds what is needed
+// to run a process as another user.
+type userCredential struct {
+	name       string
+	home       string
+	credential *syscall.Credential
+}
+
+// lookupUser returns the credential for running a process as the named
+// user, which is only possible when running as root.
+func lookupUser(name string) (*userCredential, error) {
+	if os.Geteuid() != 0 {
+		return nil, errors.New("setting the user requires root")
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, errors.Annotatef(err, "parsing uid")
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, errors.Annotatef(err, "parsing gid")
+	}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, errors.Annotatef(err, "getting groups")
+	}
+	groups := make([]uint32, len(groupIds))
+	for i, groupId := range groupIds {
+		group, err := strconv.ParseUint(groupId, 10, 32)
+		if err != nil {
+			return nil, errors.Annotatef(err, "parsing group id")
+		}
+		groups[i] = uint32(group)
+	}
+	return &userCredential{
+		name: u.Username,
+		home: u.HomeDir,
+		credential: &syscall.Credential{
+			Uid:    uint32(uid),
+			Gid:    uint32(gid),
+			Groups: groups,
+		},
+	}, nil
+}
+
+// setUser arranges for the process to run as the user,
+// with its environment updated to match.
+func (r *RunParams) setUser(u *userCredential) {
+	r.ps.SysProcAttr.Credential = u.credential
+	env := r.ps.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = setEnv(env, "HOME", u.home)
+	env = setEnv(env, "USER", u.name)
+	r.ps.Env = setEnv(env, "LOGNAME", u.name)
+}
+
+// setEnv returns env with the variable set to value.
+func setEnv(env []string, name, value string) []string {
+	var result []string
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, name+"=") {
+			result = append(result, kv)
+		}
+	}
+	return append(result, name+"="+value)
+}
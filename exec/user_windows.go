@@ -0,0 +1,23 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build windows
+// +build windows
+
+package exec
+
+import (
+	"github.com/juju/errors"
+)
+
+// userCredential is not used on windows.
+type userCredential struct{}
+
+// lookupUser returns an error, as running commands
+// as another user is not supported on windows.
+func lookupUser(name string) (*userCredential, error) {
+	return nil, errors.NotSupportedf("running as another user on windows")
+}
+
+// setUser is never called on windows, as lookupUser always fails.
+func (r *RunParams) setUser(u *userCredential) {}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/juju/errors"
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -66,6 +67,15 @@ EOF`
 	})
 }
 
+func (s bashSuite) TestWriteFileDelimiter(c *gc.C) {
+	data := []byte("EOF\nEOF1\nhere")
+	commands := s.renderer.WriteFile(s.filename, data)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"cat > '/some/dir/file' << 'EOF2'\nEOF\nEOF1\nhere\nEOF2",
+	})
+}
+
 func (s bashSuite) TestMkdir(c *gc.C) {
 	commands := s.renderer.Mkdir(s.dirname)
 
@@ -142,3 +152,78 @@ func (s bashSuite) TestScriptPermissions(c *gc.C) {
 
 	c.Check(perm, gc.Equals, os.FileMode(0755))
 }
+
+func (s bashSuite) TestAppendFile(c *gc.C) {
+	commands := s.renderer.AppendFile(s.filename, []byte("more\nEOF\nrm -rf /\n"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"printf '%s' 'more\nEOF\nrm -rf /\n' >> '/some/dir/file'",
+	})
+}
+
+func (s bashSuite) TestAppendFileBinary(c *gc.C) {
+	commands := s.renderer.AppendFile(s.filename, []byte{0, 1, 2})
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"base64 -d >> '/some/dir/file' << 'EOF'\nAAEC\nEOF",
+	})
+}
+
+func (s bashSuite) TestSetEnv(c *gc.C) {
+	commands, err := s.renderer.SetEnv("http_proxy", "http://it's/")
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`export http_proxy='http://it'"'"'s/'`,
+	})
+}
+
+func (s bashSuite) TestSetEnvInvalidName(c *gc.C) {
+	for _, name := range []string{"", "1A", "A-B", "A=B", "A;rm -rf /", "$(id)"} {
+		commands, err := s.renderer.SetEnv(name, "value")
+		c.Check(err, jc.Satisfies, errors.IsNotValid, gc.Commentf("name %q", name))
+		c.Check(commands, gc.HasLen, 0)
+	}
+}
+
+func (s bashSuite) TestRemove(c *gc.C) {
+	commands := s.renderer.Remove("/a/b/c")
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"rm -f '/a/b/c'",
+	})
+}
+
+func (s bashSuite) TestSymlink(c *gc.C) {
+	commands := s.renderer.Symlink("/a/b/c", "/d/e")
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"ln -s '/a/b/c' '/d/e'",
+	})
+}
+
+func (s bashSuite) TestCopy(c *gc.C) {
+	commands := s.renderer.Copy("/a/b/c", "/d/e")
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"cp '/a/b/c' '/d/e'",
+	})
+}
+
+func (s bashSuite) TestWriteFileIfChanged(c *gc.C) {
+	commands := s.renderer.WriteFileIfChanged(s.filename, []byte("it's\n"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if [ "$(sha256sum 2>/dev/null < '/some/dir/file' | cut -d ' ' -f 1)" != ` +
+			`'a53006eb431e3d7bd1016d0cb428baaa300d48273f3b6bc3909d098ec79fd6ba' ]; then`,
+		`printf '%s' 'it'"'"'s` + "\n" + `' > '/some/dir/file'`,
+		"fi",
+	})
+}
+
+func (s bashSuite) TestWriteFileIfChangedBinary(c *gc.C) {
+	commands := s.renderer.WriteFileIfChanged(s.filename, []byte{0, 1, 2, 0xff})
+
+	c.Check(commands, gc.HasLen, 3)
+	c.Check(commands[1], gc.Equals, "base64 -d > '/some/dir/file' << 'EOF'\nAAEC/w==\nEOF")
+}
@@ -0,0 +1,299 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package shell
+
+import (
+	"os"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// scriptOp renders a single operation of a Script. The check
+// commands must follow each command that may fail.
+type scriptOp func(r Renderer, cr ControlRenderer, check []string) ([]string, error)
+
+// Script collects shell operations independently of any shell, so
+// that the same logic can be rendered for bash, PowerShell or cmd.
+// Its methods return the Script, so that calls may be chained:
+//
+//	script := shell.NewScript().
+//		SetErrorMode(shell.ExitOnError).
+//		Section("configure", func(s *shell.Script) {
+//			s.MkdirAll("/etc/app").WriteFile("/etc/app/app.conf", data)
+//		})
+//	commands, err := script.Render(renderer)
+type Script struct {
+	mode    ErrorMode
+	handler *Script
+	ops     []scriptOp
+}
+
+// NewScript returns a new, empty Script.
+func NewScript() *Script {
+	return &Script{}
+}
+
+// SetErrorMode sets how the script handles failing commands.
+func (s *Script) SetErrorMode(mode ErrorMode) *Script {
+	s.mode = mode
+	return s
+}
+
+// OnError sets the error mode to TrapOnError, with the
+// operations added by handler run when a command fails.
+func (s *Script) OnError(handler func(*Script)) *Script {
+	s.mode = TrapOnError
+	s.handler = NewScript()
+	handler(s.handler)
+	return s
+}
+
+func (s *Script) add(op scriptOp) *Script {
+	s.ops = append(s.ops, op)
+	return s
+}
+
+// addCommand adds an operation rendered by f,
+// followed by the error check if it is not empty.
+func (s *Script) addCommand(f func(r Renderer) []string) *Script {
+	return s.add(func(r Renderer, _ ControlRenderer, check []string) ([]string, error) {
+		return withCheck(f(r), check), nil
+	})
+}
+
+// addFileCommand adds an operation rendered by f, which requires
+// the renderer to implement FileRenderer, followed by the error
+// check if it is not empty.
+func (s *Script) addFileCommand(f func(fr FileRenderer) ([]string, error)) *Script {
+	return s.add(func(r Renderer, _ ControlRenderer, check []string) ([]string, error) {
+		fr, ok := r.(FileRenderer)
+		if !ok {
+			return nil, errors.NotSupportedf("file operations with %T", r)
+		}
+		commands, err := f(fr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return withCheck(commands, check), nil
+	})
+}
+
+// withCheck returns the commands followed by
+// the check, if there are any commands.
+func withCheck(commands, check []string) []string {
+	if len(commands) == 0 {
+		return nil
+	}
+	return append(commands[:len(commands):len(commands)], check...)
+}
+
+// Run adds commands that have already been rendered for
+// the shell. They are rendered without change.
+func (s *Script) Run(commands ...string) *Script {
+	return s.addCommand(func(Renderer) []string {
+		return commands
+	})
+}
+
+// Comment adds a comment.
+func (s *Script) Comment(text string) *Script {
+	return s.add(func(_ Renderer, cr ControlRenderer, _ []string) ([]string, error) {
+		return cr.Comment(text), nil
+	})
+}
+
+// Section adds the operations added by f, preceded
+// by a blank line and a comment holding the title.
+func (s *Script) Section(title string, f func(*Script)) *Script {
+	section := NewScript()
+	f(section)
+	return s.add(func(r Renderer, cr ControlRenderer, check []string) ([]string, error) {
+		sectionCommands, err := section.renderOps(r, cr, check)
+		if err != nil {
+			return nil, errors.Annotatef(err, "section %q", title)
+		}
+		commands := append([]string{""}, cr.Comment(title)...)
+		return append(commands, sectionCommands...), nil
+	})
+}
+
+// If adds the operations added by then,
+// to be run only if the condition holds.
+func (s *Script) If(cond Condition, then func(*Script)) *Script {
+	return s.IfElse(cond, then, func(*Script) {})
+}
+
+// IfElse adds the operations added by then, to be run if the
+// condition holds, and those added by otherwise, to be run
+// if it does not.
+func (s *Script) IfElse(cond Condition, then, otherwise func(*Script)) *Script {
+	thenScript, otherwiseScript := NewScript(), NewScript()
+	then(thenScript)
+	otherwise(otherwiseScript)
+	return s.add(func(r Renderer, cr ControlRenderer, check []string) ([]string, error) {
+		thenCommands, err := thenScript.renderOps(r, cr, check)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		otherwiseCommands, err := otherwiseScript.renderOps(r, cr, check)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch {
+		case len(thenCommands) > 0:
+			return cr.If(cond, thenCommands, otherwiseCommands), nil
+		case len(otherwiseCommands) > 0:
+			return cr.If(cond.Not(), otherwiseCommands, nil), nil
+		}
+		return nil, nil
+	})
+}
+
+// Mkdir adds an operation that creates a directory.
+func (s *Script) Mkdir(dirname string) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.Mkdir(dirname)
+	})
+}
+
+// MkdirAll adds an operation that creates a directory
+// and any missing parents.
+func (s *Script) MkdirAll(dirname string) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.MkdirAll(dirname)
+	})
+}
+
+// Chmod adds an operation that sets a file's permissions.
+func (s *Script) Chmod(path string, perm os.FileMode) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.Chmod(path, perm)
+	})
+}
+
+// Chown adds an operation that sets a file's ownership.
+func (s *Script) Chown(path, user, group string) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.Chown(path, user, group)
+	})
+}
+
+// Touch adds an operation that updates a file's
+// timestamps, creating it if necessary.
+func (s *Script) Touch(path string, timestamp *time.Time) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.Touch(path, timestamp)
+	})
+}
+
+// WriteFile adds an operation that writes data to a file.
+func (s *Script) WriteFile(filename string, data []byte) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.WriteFile(filename, data)
+	})
+}
+
+// WriteFileIfChanged adds an operation that writes data
+// to a file only if its content differs.
+func (s *Script) WriteFileIfChanged(filename string, data []byte) *Script {
+	return s.addFileCommand(func(r FileRenderer) ([]string, error) {
+		return r.WriteFileIfChanged(filename, data), nil
+	})
+}
+
+// SetEnv adds an operation that sets an environment variable
+// for the rest of the script. Render fails if the name is not
+// valid, or the value cannot be represented by the shell.
+func (s *Script) SetEnv(name, value string) *Script {
+	return s.addFileCommand(func(r FileRenderer) ([]string, error) {
+		return r.SetEnv(name, value)
+	})
+}
+
+// AppendFile adds an operation that appends data to a file.
+func (s *Script) AppendFile(filename string, data []byte) *Script {
+	return s.addFileCommand(func(r FileRenderer) ([]string, error) {
+		return r.AppendFile(filename, data), nil
+	})
+}
+
+// Remove adds an operation that removes a file, if it exists.
+func (s *Script) Remove(path string) *Script {
+	return s.addFileCommand(func(r FileRenderer) ([]string, error) {
+		return r.Remove(path), nil
+	})
+}
+
+// Symlink adds an operation that creates newname
+// as a symbolic link to oldname.
+func (s *Script) Symlink(oldname, newname string) *Script {
+	return s.addFileCommand(func(r FileRenderer) ([]string, error) {
+		return r.Symlink(oldname, newname), nil
+	})
+}
+
+// Copy adds an operation that copies the src file to dst.
+func (s *Script) Copy(src, dst string) *Script {
+	return s.addFileCommand(func(r FileRenderer) ([]string, error) {
+		return r.Copy(src, dst), nil
+	})
+}
+
+// RedirectFD adds an operation that redirects the
+// src file descriptor to the dst one.
+func (s *Script) RedirectFD(dst, src string) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.RedirectFD(dst, src)
+	})
+}
+
+// RedirectOutput adds an operation that appends all
+// subsequent output of the script to the file.
+func (s *Script) RedirectOutput(filename string) *Script {
+	return s.addCommand(func(r Renderer) []string {
+		return r.RedirectOutput(filename)
+	})
+}
+
+// Render returns the script's operations as commands for the
+// renderer, which must also implement ControlRenderer, and
+// FileRenderer if the script uses any of its operations. The
+// result may be passed to WriteScript.
+func (s *Script) Render(renderer Renderer) ([]string, error) {
+	cr, ok := renderer.(ControlRenderer)
+	if !ok {
+		return nil, errors.NotSupportedf("rendering scripts with %T", renderer)
+	}
+	var handler []string
+	if s.handler != nil {
+		var err error
+		handler, err = s.handler.renderOps(renderer, cr, nil)
+		if err != nil {
+			return nil, errors.Annotate(err, "error handler")
+		}
+	}
+	eh := cr.ErrorHandling(s.mode, handler)
+	opCommands, err := s.renderOps(renderer, cr, eh.Check)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	commands := append([]string(nil), eh.Prologue...)
+	commands = append(commands, opCommands...)
+	return append(commands, eh.Epilogue...), nil
+}
+
+// renderOps renders each of the script's operations,
+// following each command that may fail with check.
+func (s *Script) renderOps(r Renderer, cr ControlRenderer, check []string) ([]string, error) {
+	var commands []string
+	for _, op := range s.ops {
+		opCommands, err := op(r, cr, check)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		commands = append(commands, opCommands...)
+	}
+	return commands, nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package shell_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/shell"
+)
+
+type builderSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&builderSuite{})
+
+func (*builderSuite) newScript() *shell.Script {
+	return shell.NewScript().
+		Comment("Set up the app.").
+		Section("directories", func(s *shell.Script) {
+			s.IfElse(shell.DirExists("/etc/app"), func(s *shell.Script) {
+				s.Comment("already there")
+			}, func(s *shell.Script) {
+				s.MkdirAll("/etc/app")
+			})
+		}).
+		WriteFile("/etc/app/conf", []byte("x"))
+}
+
+func (s *builderSuite) TestRenderBash(c *gc.C) {
+	commands, err := s.newScript().SetErrorMode(shell.ExitOnError).Render(&shell.BashRenderer{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, []string{
+		"set -e",
+		"# Set up the app.",
+		"",
+		"# directories",
+		"if [ -d '/etc/app' ]; then",
+		"# already there",
+		"else",
+		"mkdir -p '/etc/app'",
+		"fi",
+		"cat > '/etc/app/conf' << 'EOF'\nx\nEOF",
+	})
+}
+
+func (s *builderSuite) TestRenderPowershell(c *gc.C) {
+	commands, err := s.newScript().SetErrorMode(shell.ExitOnError).Render(&shell.PowershellRenderer{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, []string{
+		`$ErrorActionPreference = "Stop"`,
+		"# Set up the app.",
+		"",
+		"# directories",
+		`if (Test-Path -PathType Container '\etc\app') {`,
+		"# already there",
+		"} else {",
+		`mkdir '\etc\app'`,
+		"}",
+		"Set-Content '/etc/app/conf' @\"\nx\n\"@",
+	})
+}
+
+func (s *builderSuite) TestRenderWinCmd(c *gc.C) {
+	commands, err := s.newScript().SetErrorMode(shell.ExitOnError).Render(&shell.WinCmdRenderer{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, []string{
+		"rem Set up the app.",
+		"",
+		"rem directories",
+		`if exist ^"\etc\app\*^" (`,
+		"rem already there",
+		") else (",
+		`mkdir ^"\etc\app^"`,
+		"if errorlevel 1 exit /b",
+		")",
+		`>>^"/etc/app/conf^" @echo x`,
+		"if errorlevel 1 exit /b",
+	})
+}
+
+func (s *builderSuite) TestRenderWinCmdTrap(c *gc.C) {
+	script := shell.NewScript().
+		Run("step1").
+		OnError(func(s *shell.Script) {
+			s.Run("cleanup")
+		})
+	commands, err := script.Render(&shell.WinCmdRenderer{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, []string{
+		"step1",
+		"if errorlevel 1 goto on_error",
+		"exit /b 0",
+		":on_error",
+		"cleanup",
+		"exit /b 1",
+	})
+}
+
+func (s *builderSuite) TestIfEmpty(c *gc.C) {
+	script := shell.NewScript().
+		If(shell.PathExists("/a"), func(*shell.Script) {}).
+		IfElse(shell.PathExists("/b"), func(*shell.Script) {}, func(s *shell.Script) {
+			s.Run("touch /b")
+		})
+	commands, err := script.Render(&shell.BashRenderer{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, []string{
+		"if [ ! -e '/b' ]; then",
+		"touch /b",
+		"fi",
+	})
+}
+
+type plainRenderer struct {
+	shell.Renderer
+}
+
+func (s *builderSuite) TestRenderNotSupported(c *gc.C) {
+	_, err := shell.NewScript().Render(plainRenderer{})
+	c.Check(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *builderSuite) TestRenderSetEnvInvalid(c *gc.C) {
+	script := shell.NewScript().
+		SetEnv("PATH", "/bin").
+		If(shell.PathExists("/a"), func(s *shell.Script) {
+			s.SetEnv("A;rm -rf /", "value")
+		})
+	commands, err := script.Render(&shell.BashRenderer{})
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(err, gc.ErrorMatches, `environment variable name "A;rm -rf /" not valid`)
+	c.Check(commands, gc.IsNil)
+}
+
+// controlRenderer is a renderer that does not implement FileRenderer.
+type controlRenderer struct {
+	shell.Renderer
+	shell.ControlRenderer
+}
+
+func (s *builderSuite) TestRenderFileOperationNotSupported(c *gc.C) {
+	bash := &shell.BashRenderer{}
+	r := controlRenderer{Renderer: bash, ControlRenderer: bash}
+	commands, err := shell.NewScript().MkdirAll("/a").Render(r)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, bash.MkdirAll("/a"))
+
+	script := shell.NewScript().Section("cleanup", func(s *shell.Script) {
+		s.Remove("/a/b")
+	})
+	_, err = script.Render(r)
+	c.Check(err, jc.Satisfies, errors.IsNotSupported)
+	c.Check(err, gc.ErrorMatches, `section "cleanup": file operations with shell_test.controlRenderer not supported`)
+}
+
+// runScript renders the script for POSIX sh and runs it with
+// /bin/sh, returning its combined output and error.
+func runScript(c *gc.C, script *shell.Script) (string, error) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		c.Skip("/bin/sh not available")
+	}
+	commands, err := script.Render(&shell.ShRenderer{})
+	c.Assert(err, jc.ErrorIsNil)
+	cmd := exec.Command("/bin/sh", "-s")
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func (s *builderSuite) TestRunExitOnError(c *gc.C) {
+	out, err := runScript(c, shell.NewScript().
+		SetErrorMode(shell.ExitOnError).
+		Run("echo one", "false", "echo two"))
+	c.Check(err, gc.ErrorMatches, "exit status 1")
+	c.Check(out, gc.Equals, "one\n")
+
+	out, err = runScript(c, shell.NewScript().
+		Run("echo one", "false", "echo two"))
+	c.Check(err, jc.ErrorIsNil)
+	c.Check(out, gc.Equals, "one\ntwo\n")
+}
+
+func (s *builderSuite) TestRunTrapOnError(c *gc.C) {
+	out, err := runScript(c, shell.NewScript().
+		OnError(func(s *shell.Script) {
+			s.Run("echo cleaning up")
+		}).
+		Run("echo one", "exit 3", "echo two"))
+	c.Check(err, gc.ErrorMatches, "exit status 3")
+	c.Check(out, gc.Equals, "one\ncleaning up\n")
+
+	out, err = runScript(c, shell.NewScript().
+		OnError(func(s *shell.Script) {
+			s.Run("echo cleaning up")
+		}).
+		Run("echo one"))
+	c.Check(err, jc.ErrorIsNil)
+	c.Check(out, gc.Equals, "one\n")
+}
+
+func (s *builderSuite) TestRunConditionals(c *gc.C) {
+	dir := c.MkDir()
+	conf := filepath.Join(dir, "conf")
+	script := shell.NewScript().
+		SetErrorMode(shell.ExitOnError).
+		If(shell.PathExists(conf).Not(), func(s *shell.Script) {
+			s.WriteFile(conf, []byte("created"))
+		}).
+		IfElse(shell.DirExists(conf), func(s *shell.Script) {
+			s.Run("echo dir")
+		}, func(s *shell.Script) {
+			s.Run("echo not dir")
+		})
+	out, err := runScript(c, script)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(out, gc.Equals, "not dir\n")
+	data, err := ioutil.ReadFile(conf)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, "created")
+
+	// The file is not written again if it exists.
+	err = ioutil.WriteFile(conf, []byte("changed"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = runScript(c, script)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err = ioutil.ReadFile(conf)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, "changed")
+}
@@ -6,6 +6,8 @@ package shell
 import (
 	"os"
 	"time"
+
+	"github.com/juju/errors"
 )
 
 // CommandRenderer provides methods that may be used to generate shell
@@ -43,3 +45,59 @@ type CommandRenderer interface {
 	// before calling Touch.
 	Touch(filename string, timestamp *time.Time) []string
 }
+
+// FileRenderer provides methods that may be used to generate shell
+// commands for further file and environment operations. It is not
+// part of CommandRenderer, so that existing implementations of that
+// interface remain valid; all the renderers in this package
+// implement it.
+type FileRenderer interface {
+	// WriteFileIfChanged returns a shell command that writes the
+	// provided content to a file only if the file's content differs,
+	// so that the command may safely be run again. Unlike WriteFile,
+	// the content is written exactly, and binary content is supported.
+	WriteFileIfChanged(filename string, data []byte) []string
+
+	// SetEnv returns a shell command that sets the environment
+	// variable for the rest of the script and for the commands
+	// it runs. An error satisfying errors.IsNotValid is returned
+	// if the name is not a valid identifier, of letters, digits
+	// and underscores not starting with a digit, or if the value
+	// cannot be represented by the shell.
+	SetEnv(name, value string) ([]string, error)
+
+	// AppendFile returns a shell command that appends the provided
+	// content to a file, creating it if it does not exist.
+	AppendFile(filename string, data []byte) []string
+
+	// Remove returns a shell command that removes the named file,
+	// if it exists.
+	Remove(path string) []string
+
+	// Symlink returns a shell command that creates newname as a
+	// symbolic link to oldname. The command is functionally
+	// equivalent to os.Symlink.
+	Symlink(oldname, newname string) []string
+
+	// Copy returns a shell command that copies the src file to dst,
+	// replacing dst if it exists.
+	Copy(src, dst string) []string
+}
+
+// validateEnvName returns an error satisfying errors.IsNotValid
+// if name may not be used as an environment variable name by SetEnv.
+func validateEnvName(name string) error {
+	valid := name != ""
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			valid = false
+		}
+	}
+	if !valid {
+		return errors.NotValidf("environment variable name %q", name)
+	}
+	return nil
+}
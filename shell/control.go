@@ -0,0 +1,106 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package shell
+
+import (
+	"strings"
+)
+
+// ControlRenderer exposes the Renderer methods that relate to the
+// structure of a script, rather than to individual operations. It
+// is used to render a Script.
+type ControlRenderer interface {
+	// Comment returns the text as shell comments,
+	// one for each line of the text.
+	Comment(text string) []string
+
+	// If returns a shell conditional that runs the then commands if
+	// the condition holds, and the otherwise commands if it does not.
+	// The then commands are never empty, but otherwise may be.
+	If(cond Condition, then, otherwise []string) []string
+
+	// ErrorHandling returns the commands needed to handle errors
+	// in a script according to the mode. The handler commands are
+	// run when an error occurs in TrapOnError mode.
+	ErrorHandling(mode ErrorMode, handler []string) ErrorHandling
+}
+
+// ErrorMode determines how a script handles failing commands.
+type ErrorMode int
+
+const (
+	// ContinueOnError continues running the script
+	// after a command fails. This is the default.
+	ContinueOnError ErrorMode = iota
+
+	// ExitOnError exits the script when a command fails,
+	// as "set -e" does for unix shells.
+	ExitOnError
+
+	// TrapOnError runs an error handler and then exits
+	// the script when a command fails.
+	TrapOnError
+)
+
+// ErrorHandling holds the commands used to implement an ErrorMode.
+type ErrorHandling struct {
+	// Prologue holds the commands rendered at the
+	// start of the script.
+	Prologue []string
+
+	// Check holds the commands rendered after each operation,
+	// for shells that cannot handle errors implicitly.
+	Check []string
+
+	// Epilogue holds the commands rendered at
+	// the end of the script.
+	Epilogue []string
+}
+
+// conditionKind identifies the test made by a Condition.
+type conditionKind int
+
+const (
+	pathExists conditionKind = iota
+	dirExists
+)
+
+// Condition is a shell-independent test used by ControlRenderer.If.
+type Condition struct {
+	kind   conditionKind
+	path   string
+	negate bool
+}
+
+// PathExists returns a Condition that holds
+// if anything exists at the path.
+func PathExists(path string) Condition {
+	return Condition{kind: pathExists, path: path}
+}
+
+// DirExists returns a Condition that holds
+// if the path is an existing directory.
+func DirExists(path string) Condition {
+	return Condition{kind: dirExists, path: path}
+}
+
+// Not returns the negation of the condition.
+func (c Condition) Not() Condition {
+	c.negate = !c.negate
+	return c
+}
+
+// commentLines returns each line of the text
+// as a comment starting with prefix.
+func commentLines(prefix, text string) []string {
+	var comments []string
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			comments = append(comments, strings.TrimSpace(prefix))
+		} else {
+			comments = append(comments, prefix+line)
+		}
+	}
+	return comments
+}
@@ -6,3 +6,14 @@ package shell
 var _ Renderer = (*BashRenderer)(nil)
 var _ Renderer = (*PowershellRenderer)(nil)
 var _ Renderer = (*WinCmdRenderer)(nil)
+var _ Renderer = (*ShRenderer)(nil)
+
+var _ ControlRenderer = (*BashRenderer)(nil)
+var _ ControlRenderer = (*ShRenderer)(nil)
+var _ ControlRenderer = (*PowershellRenderer)(nil)
+var _ ControlRenderer = (*WinCmdRenderer)(nil)
+
+var _ FileRenderer = (*BashRenderer)(nil)
+var _ FileRenderer = (*ShRenderer)(nil)
+var _ FileRenderer = (*PowershellRenderer)(nil)
+var _ FileRenderer = (*WinCmdRenderer)(nil)
@@ -39,6 +39,69 @@ func (pr *PowershellRenderer) WriteFile(filename string, data []byte) []string {
 	}
 }
 
+// WriteFileIfChanged implements FileRenderer. The file's SHA256
+// checksum is compared to that of the content, which is
+// base64 encoded so that it is written exactly. As for
+// AppendFile, the path is resolved by PowerShell first.
+func (pr *PowershellRenderer) WriteFileIfChanged(filename string, data []byte) []string {
+	filename = pr.Quote(pr.FromSlash(filename))
+	return []string{
+		fmt.Sprintf("if (-not (Test-Path %s) -or (Get-FileHash -Algorithm SHA256 %s).Hash -ne '%s') {",
+			filename, filename, sha256Hex(data)),
+		fmt.Sprintf("[IO.File]::WriteAllBytes($ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s), [Convert]::FromBase64String('%s'))",
+			filename, base64.StdEncoding.EncodeToString(data)),
+		"}",
+	}
+}
+
+// AppendFile implements FileRenderer. The content is base64
+// encoded, so that it is appended exactly and nothing in
+// it is interpreted by PowerShell. The path is resolved by
+// PowerShell first, as .NET resolves relative paths against
+// the process's working directory rather than the current
+// location.
+func (pr *PowershellRenderer) AppendFile(filename string, data []byte) []string {
+	filename = pr.Quote(pr.FromSlash(filename))
+	return []string{
+		fmt.Sprintf("$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)", filename),
+		fmt.Sprintf("$data = [Convert]::FromBase64String('%s')", base64.StdEncoding.EncodeToString(data)),
+		"$file = [IO.File]::Open($path, 'Append', 'Write'); try { $file.Write($data, 0, $data.Length) } finally { $file.Close() }",
+	}
+}
+
+// SetEnv implements FileRenderer.
+func (pr *PowershellRenderer) SetEnv(name, value string) ([]string, error) {
+	if err := validateEnvName(name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{
+		fmt.Sprintf("$env:%s = %s", name, pr.Quote(value)),
+	}, nil
+}
+
+// Remove implements FileRenderer.
+func (pr *PowershellRenderer) Remove(path string) []string {
+	path = pr.Quote(pr.FromSlash(path))
+	return []string{
+		fmt.Sprintf("if (Test-Path %s) { Remove-Item -Force %s }", path, path),
+	}
+}
+
+// Symlink implements FileRenderer.
+func (pr *PowershellRenderer) Symlink(oldname, newname string) []string {
+	return []string{
+		fmt.Sprintf("New-Item -ItemType SymbolicLink -Path %s -Target %s",
+			pr.Quote(pr.FromSlash(newname)), pr.Quote(pr.FromSlash(oldname))),
+	}
+}
+
+// Copy implements FileRenderer.
+func (pr *PowershellRenderer) Copy(src, dst string) []string {
+	return []string{
+		fmt.Sprintf("Copy-Item -Force %s %s", pr.Quote(pr.FromSlash(src)), pr.Quote(pr.FromSlash(dst))),
+	}
+}
+
 // MkDir implements Renderer.
 func (pr *PowershellRenderer) Mkdir(dirname string) []string {
 	dirname = pr.FromSlash(dirname)
@@ -90,3 +153,43 @@ func NewPSEncodedCommand(script string) (string, error) {
 
 	return fmt.Sprintf(psRemoteWrapper, script), nil
 }
+
+// Comment implements ControlRenderer.
+func (pr *PowershellRenderer) Comment(text string) []string {
+	return commentLines("# ", text)
+}
+
+// If implements ControlRenderer.
+func (pr *PowershellRenderer) If(cond Condition, then, otherwise []string) []string {
+	test := "Test-Path "
+	if cond.kind == dirExists {
+		test += "-PathType Container "
+	}
+	test += pr.Quote(pr.FromSlash(cond.path))
+	if cond.negate {
+		test = fmt.Sprintf("-not (%s)", test)
+	}
+	commands := []string{fmt.Sprintf("if (%s) {", test)}
+	commands = append(commands, then...)
+	if len(otherwise) > 0 {
+		commands = append(commands, "} else {")
+		commands = append(commands, otherwise...)
+	}
+	return append(commands, "}")
+}
+
+// ErrorHandling implements ControlRenderer. Errors from
+// native commands do not stop the script, as PowerShell
+// only stops on errors from cmdlets.
+func (pr *PowershellRenderer) ErrorHandling(mode ErrorMode, handler []string) ErrorHandling {
+	switch mode {
+	case ExitOnError:
+		return ErrorHandling{Prologue: []string{`$ErrorActionPreference = "Stop"`}}
+	case TrapOnError:
+		prologue := []string{`$ErrorActionPreference = "Stop"`, "trap {"}
+		prologue = append(prologue, handler...)
+		prologue = append(prologue, "exit 1", "}")
+		return ErrorHandling{Prologue: prologue}
+	}
+	return ErrorHandling{}
+}
@@ -4,6 +4,7 @@
 package shell_test
 
 import (
+	"github.com/juju/errors"
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -90,3 +91,66 @@ func (s powershellSuite) TestNewPSEncodedCommand(c *gc.C) {
 	c.Assert(out, jc.DeepEquals, expected)
 
 }
+
+func (s powershellSuite) TestAppendFile(c *gc.C) {
+	commands := s.renderer.AppendFile("C:/some/dir/file", []byte("$(Remove-Item C:\\)\n\"@\n"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath('C:\\some\\dir\\file')",
+		"$data = [Convert]::FromBase64String('JChSZW1vdmUtSXRlbSBDOlwpCiJACg==')",
+		"$file = [IO.File]::Open($path, 'Append', 'Write'); try { $file.Write($data, 0, $data.Length) } finally { $file.Close() }",
+	})
+}
+
+func (s powershellSuite) TestSetEnv(c *gc.C) {
+	commands, err := s.renderer.SetEnv("http_proxy", "http://proxy/")
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`$env:http_proxy = 'http://proxy/'`,
+	})
+}
+
+func (s powershellSuite) TestSetEnvInvalidName(c *gc.C) {
+	for _, name := range []string{"", "1A", "A-B", "A=B", "A; Remove-Item C:\\", "$(id)"} {
+		commands, err := s.renderer.SetEnv(name, "value")
+		c.Check(err, jc.Satisfies, errors.IsNotValid, gc.Commentf("name %q", name))
+		c.Check(commands, gc.HasLen, 0)
+	}
+}
+
+func (s powershellSuite) TestRemove(c *gc.C) {
+	commands := s.renderer.Remove(s.filename)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if (Test-Path 'C:\some\dir\file') { Remove-Item -Force 'C:\some\dir\file' }`,
+	})
+}
+
+func (s powershellSuite) TestSymlink(c *gc.C) {
+	commands := s.renderer.Symlink(s.filename, `C:\link`)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`New-Item -ItemType SymbolicLink -Path 'C:\link' -Target 'C:\some\dir\file'`,
+	})
+}
+
+func (s powershellSuite) TestCopy(c *gc.C) {
+	commands := s.renderer.Copy(s.filename, `C:\copy`)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`Copy-Item -Force 'C:\some\dir\file' 'C:\copy'`,
+	})
+}
+
+func (s powershellSuite) TestWriteFileIfChanged(c *gc.C) {
+	commands := s.renderer.WriteFileIfChanged(s.filename, []byte("abc"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if (-not (Test-Path 'C:\some\dir\file') -or (Get-FileHash -Algorithm SHA256 'C:\some\dir\file').Hash -ne ` +
+			`'ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad') {`,
+		`[IO.File]::WriteAllBytes($ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath('C:\some\dir\file'), ` +
+			`[Convert]::FromBase64String('YWJj'))`,
+		"}",
+	})
+}
@@ -48,6 +48,8 @@ func NewRenderer(name string) (Renderer, error) {
 	switch name {
 	case "bash":
 		return &BashRenderer{}, nil
+	case "sh", "posix", "dash", "ash", "busybox":
+		return &ShRenderer{}, nil
 	case "ps", "powershell":
 		return &PowershellRenderer{}, nil
 	case "cmd", "batch", "bat":
@@ -66,6 +68,8 @@ func NewRenderer(name string) (Renderer, error) {
 	switch name {
 	case "ubuntu":
 		return &BashRenderer{}, nil
+	case "alpine":
+		return &ShRenderer{}, nil
 	}
 
 	return nil, errors.NotFoundf("renderer for %q", name)
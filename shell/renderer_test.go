@@ -19,6 +19,7 @@ type rendererSuite struct {
 	testing.IsolationSuite
 
 	unix    *shell.BashRenderer
+	posix   *shell.ShRenderer
 	windows *shell.PowershellRenderer
 }
 
@@ -28,6 +29,7 @@ func (s *rendererSuite) SetUpTest(c *gc.C) {
 	s.IsolationSuite.SetUpTest(c)
 
 	s.unix = &shell.BashRenderer{}
+	s.posix = &shell.ShRenderer{}
 	s.windows = &shell.PowershellRenderer{}
 }
 
@@ -37,6 +39,8 @@ func (s rendererSuite) checkRenderer(c *gc.C, renderer shell.Renderer, expected
 		c.Check(renderer, gc.FitsTypeOf, s.windows)
 	case "bash":
 		c.Check(renderer, gc.FitsTypeOf, s.unix)
+	case "sh":
+		c.Check(renderer, gc.FitsTypeOf, s.posix)
 	default:
 		c.Errorf("unknown kind %q", expected)
 	}
@@ -96,6 +100,16 @@ func (s rendererSuite) TestNewRendererDistros(c *gc.C) {
 	}
 }
 
+func (s rendererSuite) TestNewRendererSh(c *gc.C) {
+	for _, name := range []string{"sh", "SH", "posix", "dash", "ash", "busybox", "alpine"} {
+		c.Logf("trying %q", name)
+		renderer, err := shell.NewRenderer(name)
+		c.Assert(err, jc.ErrorIsNil)
+
+		s.checkRenderer(c, renderer, "sh")
+	}
+}
+
 func (s rendererSuite) TestNewRendererUnknown(c *gc.C) {
 	_, err := shell.NewRenderer("<unknown OS>")
 
@@ -0,0 +1,46 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxShFD is the highest file descriptor that
+// POSIX sh is required to support in redirections.
+const maxShFD = 9
+
+// ShRenderer is the shell renderer for POSIX sh. Its output avoids
+// bash extensions, so it may be used with minimal shells such as
+// dash or busybox ash.
+type ShRenderer struct {
+	unixRenderer
+}
+
+// WriteFile implements Renderer. Unlike BashRenderer, the content
+// is written exactly, with no trailing newline added, and may
+// contain any text other than NUL characters.
+func (sr ShRenderer) WriteFile(filename string, data []byte) []string {
+	return []string{
+		fmt.Sprintf("printf '%%s' %s > %s", sr.Quote(string(data)), sr.Quote(filename)),
+	}
+}
+
+// RedirectFD implements OutputRenderer. Only file
+// descriptors up to 9 are supported by POSIX sh.
+func (sr ShRenderer) RedirectFD(dst, src string) []string {
+	for _, name := range []string{dst, src} {
+		if fd, ok := sr.outFD(name); !ok || fd > maxShFD {
+			return nil
+		}
+	}
+	return sr.unixRenderer.RedirectFD(dst, src)
+}
+
+// RenderScript implements ScriptWriter.
+func (*ShRenderer) RenderScript(commands []string) []byte {
+	commands = append([]string{"#!/bin/sh", ""}, commands...)
+	return []byte(strings.Join(commands, "\n") + "\n")
+}
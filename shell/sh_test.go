@@ -0,0 +1,235 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package shell_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/shell"
+)
+
+type shSuite struct {
+	testing.IsolationSuite
+
+	dirname  string
+	filename string
+	renderer *shell.ShRenderer
+}
+
+var _ = gc.Suite(&shSuite{})
+
+func (s *shSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+
+	s.dirname = `/some/dir`
+	s.filename = s.dirname + `/file`
+	s.renderer = &shell.ShRenderer{}
+}
+
+// runSh runs the commands with /bin/sh, returning the combined output.
+func runSh(c *gc.C, commands ...string) string {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		c.Skip("/bin/sh not available")
+	}
+	cmd := exec.Command("/bin/sh", "-s")
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, jc.ErrorIsNil, gc.Commentf("output: %s", out))
+	return string(out)
+}
+
+func (s shSuite) TestShQuote(c *gc.C) {
+	quoted := s.renderer.Quote("abc")
+
+	c.Check(quoted, gc.Equals, `'abc'`)
+}
+
+func (s shSuite) TestWriteFile(c *gc.C) {
+	data := []byte("something\nhere\n")
+	commands := s.renderer.WriteFile(s.filename, data)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"printf '%s' 'something\nhere\n' > '/some/dir/file'",
+	})
+}
+
+func (s shSuite) TestWriteFileRun(c *gc.C) {
+	filename := filepath.Join(c.MkDir(), "it's a file")
+	data := "EOF\n'quoted' \"double\" $HOME `date` \\n %s\nno trailing newline"
+	runSh(c, s.renderer.WriteFile(filename, []byte(data))...)
+
+	written, err := ioutil.ReadFile(filename)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(written), gc.Equals, data)
+}
+
+func (s shSuite) TestQuoteRun(c *gc.C) {
+	for _, str := range []string{"", "abc", "a b", "it's", `"$x" \ *`, "line\nbreak"} {
+		out := runSh(c, "printf '%s' "+s.renderer.Quote(str))
+		c.Check(out, gc.Equals, str)
+	}
+}
+
+func (s shSuite) TestRedirectFD(c *gc.C) {
+	commands := s.renderer.RedirectFD("stdout", "stderr")
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"exec 2>&1",
+	})
+	c.Check(runSh(c, append(commands, "echo oops >&2")...), gc.Equals, "oops\n")
+}
+
+func (s shSuite) TestRedirectFDOutOfRange(c *gc.C) {
+	c.Check(s.renderer.RedirectFD("stdout", "10"), gc.HasLen, 0)
+	c.Check(s.renderer.RedirectFD("10", "stderr"), gc.HasLen, 0)
+	c.Check(s.renderer.RedirectFD("9", "stderr"), jc.DeepEquals, []string{
+		"exec 2>&9",
+	})
+}
+
+func (s shSuite) TestRedirectOutput(c *gc.C) {
+	filename := filepath.Join(c.MkDir(), "out")
+	commands := append(s.renderer.Touch(filename, nil), s.renderer.RedirectOutput(filename)...)
+	runSh(c, append(commands, "echo one", "echo two")...)
+
+	written, err := ioutil.ReadFile(filename)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(written), gc.Equals, "one\ntwo\n")
+}
+
+func (s shSuite) TestScriptFilename(c *gc.C) {
+	filename := s.renderer.ScriptFilename("spam", "/ham/eggs")
+
+	c.Check(filename, gc.Equals, "/ham/eggs/spam.sh")
+}
+
+func (s shSuite) TestWriteScript(c *gc.C) {
+	commands := shell.WriteScript(s.renderer, "spam", "/ham/eggs", []string{"exec a-command"})
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"printf '%s' '#!/bin/sh\n\nexec a-command\n' > '/ham/eggs/spam.sh'",
+		"chmod 0755 '/ham/eggs/spam.sh'",
+	})
+}
+
+func (s shSuite) TestWriteScriptRun(c *gc.C) {
+	dirname := c.MkDir()
+	script := []string{
+		"echo \"running $0\"",
+		"echo 'single' \"double\"",
+	}
+	commands := append(s.renderer.MkdirAll(dirname), shell.WriteScript(s.renderer, "spam", dirname, script)...)
+	runSh(c, commands...)
+
+	filename := s.renderer.ScriptFilename("spam", dirname)
+	info, err := os.Stat(filename)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(info.Mode().Perm(), gc.Equals, os.FileMode(0755))
+
+	out, err := exec.Command(filename).CombinedOutput()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(out), gc.Equals, "running "+filename+"\nsingle double\n")
+}
+
+func (s shSuite) TestAppendFile(c *gc.C) {
+	commands := s.renderer.AppendFile(s.filename, []byte("more\n"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		"printf '%s' 'more\n' >> '/some/dir/file'",
+	})
+}
+
+func (s shSuite) TestAppendFileRun(c *gc.C) {
+	filename := filepath.Join(c.MkDir(), "file")
+	text := []byte("EOF\necho injected\n'quoted' $HOME\n")
+	binary := []byte{0, 'E', 'O', 'F', '\n', 0xff}
+	var commands []string
+	commands = append(commands, s.renderer.AppendFile(filename, text)...)
+	commands = append(commands, s.renderer.AppendFile(filename, binary)...)
+	out := runSh(c, commands...)
+	c.Check(out, gc.Equals, "")
+
+	data, err := ioutil.ReadFile(filename)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(data, jc.DeepEquals, append(text, binary...))
+}
+
+func (s shSuite) TestFileOperationsRun(c *gc.C) {
+	dir := c.MkDir()
+	original := filepath.Join(dir, "original")
+	copied := filepath.Join(dir, "copied file")
+	link := filepath.Join(dir, "link")
+	removed := filepath.Join(dir, "removed")
+	err := ioutil.WriteFile(removed, nil, 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	var commands []string
+	commands = append(commands, s.renderer.WriteFile(original, []byte("one\n"))...)
+	commands = append(commands, s.renderer.AppendFile(original, []byte("it's two\n"))...)
+	commands = append(commands, s.renderer.Copy(original, copied)...)
+	commands = append(commands, s.renderer.Symlink(original, link)...)
+	commands = append(commands, s.renderer.Remove(removed)...)
+	commands = append(commands, s.renderer.Remove(removed)...)
+	setEnv, err := s.renderer.SetEnv("GREETING", "hello 'world' $HOME")
+	c.Assert(err, jc.ErrorIsNil)
+	commands = append(commands, setEnv...)
+	commands = append(commands, `sh -c 'printf "%s" "$GREETING"'`)
+	out := runSh(c, commands...)
+	c.Check(out, gc.Equals, "hello 'world' $HOME")
+
+	data, err := ioutil.ReadFile(copied)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, "one\nit's two\n")
+	target, err := os.Readlink(link)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(target, gc.Equals, original)
+	_, err = os.Stat(removed)
+	c.Check(err, jc.Satisfies, os.IsNotExist)
+}
+
+func (s shSuite) TestWriteFileIfChangedRun(c *gc.C) {
+	binary := make([]byte, 1000)
+	for i := range binary {
+		binary[i] = byte(i * 7)
+	}
+	for i, data := range [][]byte{
+		[]byte("EOF\n'quoted' $HOME\nno trailing newline"),
+		binary,
+		nil,
+	} {
+		c.Logf("test %d", i)
+		filename := filepath.Join(c.MkDir(), "file")
+		commands := s.renderer.WriteFileIfChanged(filename, data)
+		runSh(c, commands...)
+		written, err := ioutil.ReadFile(filename)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(written, jc.DeepEquals, data[:len(data):len(data)])
+
+		// The file is not written again when unchanged.
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		err = os.Chtimes(filename, past, past)
+		c.Assert(err, jc.ErrorIsNil)
+		runSh(c, commands...)
+		info, err := os.Stat(filename)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(info.ModTime().Equal(past), jc.IsTrue)
+
+		// Local changes are replaced.
+		err = ioutil.WriteFile(filename, []byte("changed"), 0644)
+		c.Assert(err, jc.ErrorIsNil)
+		runSh(c, commands...)
+		written, err = ioutil.ReadFile(filename)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(written), gc.Equals, string(data))
+	}
+}
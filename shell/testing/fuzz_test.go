@@ -0,0 +1,179 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package testing_test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/juju/utils/v4"
+	"github.com/juju/utils/v4/shell"
+	shelltesting "github.com/juju/utils/v4/shell/testing"
+)
+
+// quotingCorpus seeds the quoting fuzz tests.
+var quotingCorpus = []string{
+	"",
+	"abc",
+	"a b\tc\nd",
+	"it's",
+	`"double"`,
+	`$HOME ${x} $(ls) ` + "`ls`",
+	`C:\dir\`,
+	`\\server\share`,
+	`a\"b\\"c`,
+	`()%!^"<>&|;*?[]~#=`,
+	"it\u2018s\u2019\u201a\u201b",
+	"\u00e9\u4e16\u754c",
+}
+
+func addQuotingCorpus(f *testing.F) {
+	for _, s := range quotingCorpus {
+		f.Add(s)
+	}
+}
+
+// shQuotable reports whether s can be represented in a
+// shell word at all, as shell strings cannot hold NULs.
+func shQuotable(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+func FuzzShQuote(f *testing.F) {
+	addQuotingCorpus(f)
+	f.Fuzz(func(t *testing.T, s string) {
+		if !shQuotable(s) {
+			t.Skip()
+		}
+		for _, quoted := range []string{utils.ShQuote(s), (&shell.BashRenderer{}).Quote(s)} {
+			value, err := shelltesting.UnquoteSh(quoted)
+			if err != nil {
+				t.Fatalf("unquoting %q: %v", quoted, err)
+			}
+			if value != s {
+				t.Fatalf("%q unquoted to %q, expected %q", quoted, value, s)
+			}
+		}
+	})
+}
+
+func FuzzWinPSQuote(f *testing.F) {
+	addQuotingCorpus(f)
+	f.Fuzz(func(t *testing.T, s string) {
+		if !utf8.ValidString(s) {
+			t.Skip()
+		}
+		quoted := utils.WinPSQuote(s)
+		value, err := shelltesting.UnquotePowershell(quoted)
+		if err != nil {
+			t.Fatalf("unquoting %q: %v", quoted, err)
+		}
+		// WinPSQuote replaces single quotes with double quotes.
+		expected := strings.Map(func(r rune) rune {
+			if strings.ContainsRune("'\u2018\u2019\u201a\u201b", r) {
+				return '"'
+			}
+			return r
+		}, s)
+		if value != expected {
+			t.Fatalf("%q unquoted to %q, expected %q", quoted, value, expected)
+		}
+	})
+}
+
+func FuzzWinCmdQuote(f *testing.F) {
+	addQuotingCorpus(f)
+	f.Fuzz(func(t *testing.T, s string) {
+		if !utf8.ValidString(s) || strings.ContainsAny(s, "\x00\r\n") {
+			// Line breaks end a command in cmd.exe.
+			t.Skip()
+		}
+		quoted := utils.WinCmdQuote(s)
+		value, err := shelltesting.UnquoteWinCmd(quoted)
+		if err != nil {
+			t.Fatalf("unquoting %q: %v", quoted, err)
+		}
+		if value != s {
+			t.Fatalf("%q unquoted to %q, expected %q", quoted, value, s)
+		}
+	})
+}
+
+// commandLines joins the rendered commands making up each if
+// statement, so that each returned line holds a single command.
+func commandLines(commands []string) []string {
+	var lines []string
+	depth := 0
+	for _, command := range commands {
+		if depth > 0 {
+			lines[len(lines)-1] += "\n" + command
+		} else {
+			lines = append(lines, command)
+		}
+		switch {
+		case strings.HasPrefix(command, "if ") && strings.HasSuffix(command, "; then"):
+			depth++
+		case command == "fi":
+			depth--
+		}
+	}
+	return lines
+}
+
+// countCommands returns the number of commands in script.
+func countCommands(script string, lang syntax.LangVariant) (int, error) {
+	file, err := syntax.NewParser(syntax.Variant(lang)).Parse(strings.NewReader(script), "")
+	if err != nil {
+		return 0, err
+	}
+	return len(file.Stmts), nil
+}
+
+func FuzzBashRendererPaths(f *testing.F) {
+	for _, s := range quotingCorpus {
+		f.Add(s, []byte(s))
+	}
+	f.Add("/a", []byte("EOF\nrm -rf /\nEOF\n"))
+	f.Add("/a", []byte{0, 1, 0xff})
+	f.Fuzz(func(t *testing.T, path string, data []byte) {
+		if !shQuotable(path) {
+			t.Skip()
+		}
+		// WriteFile only supports text, so other
+		// data is only written by the other methods.
+		text := data
+		if !shQuotable(string(data)) {
+			text = []byte("data")
+		}
+		for _, test := range []struct {
+			renderer renderer
+			parse    func(string) error
+			lang     syntax.LangVariant
+		}{
+			{&shell.BashRenderer{}, shelltesting.ParseBash, syntax.LangBash},
+			{&shell.ShRenderer{}, shelltesting.ParsePOSIX, syntax.LangPOSIX},
+		} {
+			r := test.renderer
+			commands := renderAll(r, path, text)
+			commands = append(commands, r.WriteFileIfChanged(path, data)...)
+			commands = append(commands, r.AppendFile(path, data)...)
+			script := strings.Join(commands, "\n")
+			if err := test.parse(script); err != nil {
+				t.Fatalf("parsing %q: %v", script, err)
+			}
+			for _, line := range commandLines(commands) {
+				n, err := countCommands(line, test.lang)
+				if err != nil {
+					t.Fatalf("parsing %q: %v", line, err)
+				}
+				if n != 1 {
+					t.Fatalf("%q parsed as %d commands, expected 1", line, n)
+				}
+			}
+		}
+	})
+}
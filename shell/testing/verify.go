@@ -0,0 +1,191 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package testing provides helpers for verifying the scripts and
+// quoted strings generated by the shell package and by the quoting
+// functions in the utils package.
+package testing
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ParseBash returns an error if script is not valid bash.
+func ParseBash(script string) error {
+	return parse(script, syntax.LangBash)
+}
+
+// ParsePOSIX returns an error if script is not valid POSIX sh.
+func ParsePOSIX(script string) error {
+	return parse(script, syntax.LangPOSIX)
+}
+
+func parse(script string, lang syntax.LangVariant) error {
+	parser := syntax.NewParser(syntax.Variant(lang))
+	_, err := parser.Parse(strings.NewReader(script), "")
+	return errors.Trace(err)
+}
+
+// UnquoteSh returns the value of word, which must be a single word
+// quoted for bash or POSIX sh, such as is returned by utils.ShQuote.
+// An error is returned if any part of the word is unquoted, or is
+// subject to expansion.
+func UnquoteSh(word string) (string, error) {
+	parser := syntax.NewParser(syntax.Variant(syntax.LangBash))
+	file, err := parser.Parse(strings.NewReader("echo "+word), "")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(file.Stmts) != 1 {
+		return "", errors.Errorf("%q is not a single word", word)
+	}
+	call, ok := file.Stmts[0].Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) != 2 || len(file.Stmts[0].Redirs) > 0 {
+		return "", errors.Errorf("%q is not a single word", word)
+	}
+	var value strings.Builder
+	for _, part := range call.Args[1].Parts {
+		switch part := part.(type) {
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				return "", errors.Errorf("%q contains ANSI-C quoting", word)
+			}
+			value.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			if part.Dollar {
+				return "", errors.Errorf("%q contains locale quoting", word)
+			}
+			for _, part := range part.Parts {
+				lit, ok := part.(*syntax.Lit)
+				if !ok {
+					return "", errors.Errorf("%q contains expansions", word)
+				}
+				value.WriteString(unescapeDblQuoted(lit.Value))
+			}
+		default:
+			return "", errors.Errorf("%q contains unquoted text", word)
+		}
+	}
+	return value.String(), nil
+}
+
+// unescapeDblQuoted removes the backslashes that escape
+// characters within double quotes.
+func unescapeDblQuoted(s string) string {
+	var value strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '$', '`', '"', '\\':
+				i++
+			case '\n':
+				i++
+				continue
+			}
+		}
+		value.WriteByte(s[i])
+	}
+	return value.String()
+}
+
+// psSingleQuotes holds the characters that PowerShell
+// treats as single quotes.
+const psSingleQuotes = "'‘’‚‛"
+
+// UnquotePowershell returns the value of word, which must be a
+// single quoted PowerShell string, such as is returned by
+// utils.WinPSQuote.
+func UnquotePowershell(word string) (string, error) {
+	runes := []rune(word)
+	if len(runes) < 2 || !isPSSingleQuote(runes[0]) || !isPSSingleQuote(runes[len(runes)-1]) {
+		return "", errors.Errorf("%q is not single quoted", word)
+	}
+	var value strings.Builder
+	runes = runes[1 : len(runes)-1]
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if isPSSingleQuote(r) {
+			// Quotes are escaped by doubling them.
+			if i+1 == len(runes) || !isPSSingleQuote(runes[i+1]) {
+				return "", errors.Errorf("%q contains an unescaped quote", word)
+			}
+			i++
+		}
+		value.WriteRune(r)
+	}
+	return value.String(), nil
+}
+
+func isPSSingleQuote(r rune) bool {
+	return strings.ContainsRune(psSingleQuotes, r)
+}
+
+// winCmdMeta holds the characters that cmd.exe interprets
+// unless they are escaped with a caret.
+const winCmdMeta = `()%!^"<>&|`
+
+// UnquoteWinCmd returns the value of word, which must be a
+// single argument quoted for cmd.exe, such as is returned by
+// utils.WinCmdQuote. All cmd.exe metacharacters must be escaped,
+// and the argument is then parsed following the rules used by
+// CommandLineToArgvW.
+func UnquoteWinCmd(word string) (string, error) {
+	var unescaped strings.Builder
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if c == '^' {
+			if i+1 == len(word) {
+				return "", errors.Errorf("%q ends with an escape", word)
+			}
+			i++
+			c = word[i]
+		} else if strings.IndexByte(winCmdMeta, c) >= 0 {
+			return "", errors.Errorf("%q contains unescaped %q", word, c)
+		}
+		unescaped.WriteByte(c)
+	}
+	return parseWinArg(unescaped.String())
+}
+
+// parseWinArg parses s as a single command line argument.
+func parseWinArg(s string) (string, error) {
+	var value strings.Builder
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			n := 1
+			for i+n < len(s) && s[i+n] == '\\' {
+				n++
+			}
+			i += n - 1
+			if i+1 < len(s) && s[i+1] == '"' {
+				// Backslashes before a quote escape each other,
+				// and an odd one out escapes the quote.
+				value.WriteString(strings.Repeat(`\`, n/2))
+				if n%2 == 1 {
+					value.WriteByte('"')
+					i++
+				}
+			} else {
+				value.WriteString(strings.Repeat(`\`, n))
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case ' ', '\t':
+			if !inQuotes {
+				return "", errors.Errorf("%q is not a single argument", s)
+			}
+			value.WriteByte(c)
+		default:
+			value.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return "", errors.Errorf("%q has an unterminated quote", s)
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package testing_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/shell"
+	shelltesting "github.com/juju/utils/v4/shell/testing"
+)
+
+type verifySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&verifySuite{})
+
+func (*verifySuite) TestParseBash(c *gc.C) {
+	c.Check(shelltesting.ParseBash("if [ -e x ]; then echo 'a b'; fi"), jc.ErrorIsNil)
+	c.Check(shelltesting.ParseBash("[[ -e x ]] && echo <(ls)"), jc.ErrorIsNil)
+	c.Check(shelltesting.ParseBash("echo 'unterminated"), gc.ErrorMatches, ".*reached EOF without closing quote.*")
+}
+
+func (*verifySuite) TestParsePOSIX(c *gc.C) {
+	c.Check(shelltesting.ParsePOSIX("if [ -e x ]; then echo 'a b'; fi"), jc.ErrorIsNil)
+	c.Check(shelltesting.ParsePOSIX("echo <(ls)"), gc.NotNil)
+}
+
+func (*verifySuite) TestUnquoteSh(c *gc.C) {
+	for i, test := range []struct {
+		word  string
+		value string
+		err   string
+	}{
+		{word: `'a b'`, value: "a b"},
+		{word: `'it'"'"'s'`, value: "it's"},
+		{word: `"a \"b\" \$c"`, value: `a "b" $c`},
+		{word: `''`, value: ""},
+		{word: `abc`, err: `"abc" contains unquoted text`},
+		{word: `'a'b`, err: `"'a'b" contains unquoted text`},
+		{word: `"$HOME"`, err: `"\\"\$HOME\\"" contains expansions`},
+		{word: `$'a'`, err: `"\$'a'" contains ANSI-C quoting`},
+		{word: `'a' 'b'`, err: `"'a' 'b'" is not a single word`},
+		{word: `'a'; ls`, err: `"'a'; ls" is not a single word`},
+		{word: `'a`, err: ".*reached EOF without closing quote.*"},
+	} {
+		c.Logf("test %d: %s", i, test.word)
+		value, err := shelltesting.UnquoteSh(test.word)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(value, gc.Equals, test.value)
+	}
+}
+
+func (*verifySuite) TestUnquotePowershell(c *gc.C) {
+	for i, test := range []struct {
+		word  string
+		value string
+		err   string
+	}{
+		{word: `'a b'`, value: "a b"},
+		{word: `'it''s'`, value: "it's"},
+		{word: `'$a'`, value: "$a"},
+		{word: `a`, err: `"a" is not single quoted`},
+		{word: `'it's'`, err: `"'it's'" contains an unescaped quote`},
+		{word: "'it\u2019s'", err: `".*" contains an unescaped quote`},
+	} {
+		c.Logf("test %d: %s", i, test.word)
+		value, err := shelltesting.UnquotePowershell(test.word)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(value, gc.Equals, test.value)
+	}
+}
+
+func (*verifySuite) TestUnquoteWinCmd(c *gc.C) {
+	for i, test := range []struct {
+		word  string
+		value string
+		err   string
+	}{
+		{word: `^"a b^"`, value: "a b"},
+		{word: `^"C:\a\b\\^"`, value: `C:\a\b\`},
+		{word: `^"a\\\^"b^"`, value: `a\"b`},
+		{word: `^"a ^& b^"`, value: "a & b"},
+		{word: `^"a & b^"`, err: `".*" contains unescaped '&'`},
+		{word: `"a"`, err: `".*" contains unescaped '"'`},
+		{word: `a^`, err: `"a\^" ends with an escape`},
+		{word: `a b`, err: `"a b" is not a single argument`},
+		{word: `^"a`, err: `"\\"a" has an unterminated quote`},
+	} {
+		c.Logf("test %d: %s", i, test.word)
+		value, err := shelltesting.UnquoteWinCmd(test.word)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(value, gc.Equals, test.value)
+	}
+}
+
+// renderer is implemented by the unix shell renderers.
+type renderer interface {
+	shell.Renderer
+	shell.FileRenderer
+	shell.ScriptWriter
+}
+
+// renderAll returns the commands for every CommandRenderer,
+// FileRenderer and OutputRenderer method.
+func renderAll(r renderer, path string, data []byte) []string {
+	now := time.Date(2015, time.March, 14, 12, 26, 38, 0, time.UTC)
+	// The name is valid, and unix shells can represent any
+	// value, so no error is returned.
+	setEnv, _ := r.SetEnv("NAME", path)
+	var commands []string
+	for _, cmds := range [][]string{
+		r.Mkdir(path),
+		r.MkdirAll(path),
+		r.Chmod(path, 0644),
+		r.Chown(path, "user", "group"),
+		r.Touch(path, nil),
+		r.Touch(path, &now),
+		r.WriteFile(path, data),
+		r.WriteFileIfChanged(path, data),
+		// Only WriteFileIfChanged supports binary content.
+		r.WriteFileIfChanged(path, []byte{0, 1, 0xff}),
+		r.AppendFile(path, data),
+		setEnv,
+		r.Remove(path),
+		r.Symlink(path, path+".link"),
+		r.Copy(path, path+".copy"),
+		r.RedirectFD("stdout", "stderr"),
+		r.RedirectOutput(path),
+		r.RedirectOutputReset(path),
+		shell.WriteScript(r, "script", path, []string{"echo hello"}),
+	} {
+		commands = append(commands, cmds...)
+	}
+	return commands
+}
+
+func (*verifySuite) TestRenderersGenerateValidScripts(c *gc.C) {
+	paths := []string{"/a/b", "/it's here", `/$HOME/"x"/*`, "/line\nbreak"}
+	data := [][]byte{[]byte("text\n"), []byte("'quoted' $HOME `cmd`\nEOFX")}
+	script := shell.NewScript().
+		OnError(func(s *shell.Script) { s.Run("echo failed") }).
+		Section("setup", func(s *shell.Script) {
+			s.IfElse(shell.DirExists("/a").Not(), func(s *shell.Script) {
+				s.MkdirAll("/a")
+			}, func(s *shell.Script) {
+				s.Comment("exists\nalready")
+			})
+		})
+	for _, path := range paths {
+		for _, data := range data {
+			c.Logf("path %q, data %q", path, data)
+			bashCommands := renderAll(&shell.BashRenderer{}, path, data)
+			script.Run(bashCommands...)
+			c.Check(shelltesting.ParseBash(strings.Join(bashCommands, "\n")), jc.ErrorIsNil)
+			shCommands := renderAll(&shell.ShRenderer{}, path, data)
+			c.Check(shelltesting.ParsePOSIX(strings.Join(shCommands, "\n")), jc.ErrorIsNil)
+		}
+	}
+	for _, r := range []renderer{&shell.BashRenderer{}, &shell.ShRenderer{}} {
+		commands, err := script.Render(r)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(shelltesting.ParsePOSIX(string(r.RenderScript(commands))), jc.ErrorIsNil)
+	}
+}
@@ -6,8 +6,11 @@ package shell
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/juju/errors"
+
 	"github.com/juju/utils/v4"
 	"github.com/juju/utils/v4/filepath"
 )
@@ -75,9 +78,73 @@ func (ur unixRenderer) Touch(path string, timestamp *time.Time) []string {
 // WriteFile implements Renderer.
 func (ur unixRenderer) WriteFile(filename string, data []byte) []string {
 	filename = ur.Quote(filename)
+	delimiter := heredocDelimiter(data)
 	return []string{
 		// An alternate approach would be to use printf.
-		fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", filename, data),
+		fmt.Sprintf("cat > %s << '%s'\n%s\n%s", filename, delimiter, data, delimiter),
+	}
+}
+
+// WriteFileIfChanged implements FileRenderer. The file's SHA256
+// checksum is compared to that of the content using sha256sum,
+// and binary content is decoded using base64.
+func (ur unixRenderer) WriteFileIfChanged(filename string, data []byte) []string {
+	filename = ur.Quote(filename)
+	return []string{
+		fmt.Sprintf(`if [ "$(sha256sum 2>/dev/null < %s | cut -d ' ' -f 1)" != '%s' ]; then`, filename, sha256Hex(data)),
+		ur.writeData(">", filename, data),
+		"fi",
+	}
+}
+
+// AppendFile implements FileRenderer. The content is appended exactly,
+// with no trailing newline added.
+func (ur unixRenderer) AppendFile(filename string, data []byte) []string {
+	return []string{
+		ur.writeData(">>", ur.Quote(filename), data),
+	}
+}
+
+// writeData returns a command that writes data exactly to the quoted
+// filename through the redirect operator. Text is quoted and written
+// with printf, and binary content is decoded using base64. No base64
+// line can end the heredoc early, as each is a multiple of four
+// characters long.
+func (ur unixRenderer) writeData(redirect, filename string, data []byte) string {
+	if isText(data) {
+		return fmt.Sprintf("printf '%%s' %s %s %s", ur.Quote(string(data)), redirect, filename)
+	}
+	return fmt.Sprintf("base64 -d %s %s << 'EOF'\n%s\nEOF", redirect, filename, strings.Join(base64Lines(data), "\n"))
+}
+
+// SetEnv implements FileRenderer.
+func (ur unixRenderer) SetEnv(name, value string) ([]string, error) {
+	if err := validateEnvName(name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{
+		fmt.Sprintf("export %s=%s", name, ur.Quote(value)),
+	}, nil
+}
+
+// Remove implements FileRenderer.
+func (ur unixRenderer) Remove(path string) []string {
+	return []string{
+		fmt.Sprintf("rm -f %s", ur.Quote(path)),
+	}
+}
+
+// Symlink implements FileRenderer.
+func (ur unixRenderer) Symlink(oldname, newname string) []string {
+	return []string{
+		fmt.Sprintf("ln -s %s %s", ur.Quote(oldname), ur.Quote(newname)),
+	}
+}
+
+// Copy implements FileRenderer.
+func (ur unixRenderer) Copy(src, dst string) []string {
+	return []string{
+		fmt.Sprintf("cp %s %s", ur.Quote(src), ur.Quote(dst)),
 	}
 }
 
@@ -131,3 +198,54 @@ func (ur *unixRenderer) ScriptFilename(name, dirname string) string {
 func (ur *unixRenderer) ScriptPermissions() os.FileMode {
 	return 0755
 }
+
+// Comment implements ControlRenderer.
+func (unixRenderer) Comment(text string) []string {
+	return commentLines("# ", text)
+}
+
+// If implements ControlRenderer.
+func (ur unixRenderer) If(cond Condition, then, otherwise []string) []string {
+	op := "-e"
+	if cond.kind == dirExists {
+		op = "-d"
+	}
+	if cond.negate {
+		op = "! " + op
+	}
+	commands := []string{fmt.Sprintf("if [ %s %s ]; then", op, ur.Quote(cond.path))}
+	commands = append(commands, then...)
+	if len(otherwise) > 0 {
+		commands = append(commands, "else")
+		commands = append(commands, otherwise...)
+	}
+	return append(commands, "fi")
+}
+
+// ErrorHandling implements ControlRenderer.
+func (unixRenderer) ErrorHandling(mode ErrorMode, handler []string) ErrorHandling {
+	switch mode {
+	case ExitOnError:
+		return ErrorHandling{Prologue: []string{"set -e"}}
+	case TrapOnError:
+		if len(handler) == 0 {
+			handler = []string{":"}
+		}
+		prologue := []string{
+			"on_error() {",
+			"    code=$?",
+			"    set +e",
+			"    if [ $code -ne 0 ]; then",
+		}
+		prologue = append(prologue, handler...)
+		prologue = append(prologue,
+			"    fi",
+			"    exit $code",
+			"}",
+			"trap on_error EXIT",
+			"set -e",
+		)
+		return ErrorHandling{Prologue: prologue}
+	}
+	return ErrorHandling{}
+}
@@ -7,6 +7,9 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strings"
+
+	"github.com/juju/errors"
 
 	"github.com/juju/utils/v4"
 )
@@ -38,6 +41,111 @@ func (wcr *WinCmdRenderer) WriteFile(filename string, data []byte) []string {
 	return commands
 }
 
+// WriteFileIfChanged implements FileRenderer. The content is base64
+// encoded and decoded using certutil, and the file is only replaced
+// if its SHA256 checksum, as computed by certutil, differs from that
+// of the content.
+func (wcr *WinCmdRenderer) WriteFileIfChanged(filename string, data []byte) []string {
+	filename = wcr.FromSlash(filename)
+	if len(data) == 0 {
+		// certutil cannot hash empty files, so the file
+		// is only truncated if it has any lines.
+		filename = wcr.Quote(filename)
+		return []string{
+			fmt.Sprintf("if not exist %s type nul > %s", filename, filename),
+			fmt.Sprintf(`findstr /r "^" %s >nul 2>&1 && type nul > %s`, filename, filename),
+		}
+	}
+	decoded := wcr.Quote(filename + ".new")
+	commands := wcr.decode(filename, data)
+	filename = wcr.Quote(filename)
+	return append(commands,
+		fmt.Sprintf("certutil -hashfile %s SHA256 2>nul | findstr /x /i %s >nul || move /y %s %s >nul",
+			filename, sha256Hex(data), decoded, filename),
+		fmt.Sprintf("if exist %s del /f /q %s", decoded, decoded),
+	)
+}
+
+// AppendFile implements FileRenderer. As for WriteFileIfChanged,
+// the content is base64 encoded and decoded using certutil, so
+// that it is appended exactly and nothing in it is interpreted
+// by cmd.exe.
+func (wcr *WinCmdRenderer) AppendFile(filename string, data []byte) []string {
+	filename = wcr.FromSlash(filename)
+	if len(data) == 0 {
+		// certutil cannot decode empty files.
+		filename = wcr.Quote(filename)
+		return []string{
+			fmt.Sprintf("if not exist %s type nul > %s", filename, filename),
+		}
+	}
+	decoded := wcr.Quote(filename + ".new")
+	commands := wcr.decode(filename, data)
+	filename = wcr.Quote(filename)
+	return append(commands,
+		fmt.Sprintf("if not exist %s move /y %s %s >nul", filename, decoded, filename),
+		fmt.Sprintf("if exist %s copy /b %s+%s %s >nul", decoded, filename, decoded, filename),
+		fmt.Sprintf("if exist %s del /f /q %s", decoded, decoded),
+	)
+}
+
+// decode returns commands that write the data, which must not be
+// empty, to filename with a ".new" suffix, by decoding its base64
+// encoding with certutil.
+func (wcr *WinCmdRenderer) decode(filename string, data []byte) []string {
+	encoded := wcr.Quote(filename + ".b64")
+	decoded := wcr.Quote(filename + ".new")
+	commands := []string{
+		fmt.Sprintf("if exist %s del /f /q %s", encoded, encoded),
+	}
+	for _, line := range base64Lines(data) {
+		commands = append(commands, fmt.Sprintf(">>%s @echo %s", encoded, line))
+	}
+	return append(commands,
+		fmt.Sprintf("certutil -f -decode %s %s >nul", encoded, decoded),
+		fmt.Sprintf("del /f /q %s", encoded),
+	)
+}
+
+// SetEnv implements FileRenderer. Percent signs in the value are
+// escaped, as they would otherwise be expanded in a batch file.
+// Values containing double quotes or line breaks are not valid, as
+// they would end the quoted assignment, and cannot be escaped.
+func (wcr *WinCmdRenderer) SetEnv(name, value string) ([]string, error) {
+	if err := validateEnvName(name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if strings.ContainsAny(value, "\"\r\n") {
+		return nil, errors.NotValidf("value %q for environment variable %s", value, name)
+	}
+	value = strings.Replace(value, "%", "%%", -1)
+	return []string{
+		fmt.Sprintf(`set "%s=%s"`, name, value),
+	}, nil
+}
+
+// Remove implements FileRenderer.
+func (wcr *WinCmdRenderer) Remove(path string) []string {
+	path = wcr.Quote(wcr.FromSlash(path))
+	return []string{
+		fmt.Sprintf("if exist %s del /f /q %s", path, path),
+	}
+}
+
+// Symlink implements FileRenderer.
+func (wcr *WinCmdRenderer) Symlink(oldname, newname string) []string {
+	return []string{
+		fmt.Sprintf("mklink %s %s", wcr.Quote(wcr.FromSlash(newname)), wcr.Quote(wcr.FromSlash(oldname))),
+	}
+}
+
+// Copy implements FileRenderer.
+func (wcr *WinCmdRenderer) Copy(src, dst string) []string {
+	return []string{
+		fmt.Sprintf("copy /y %s %s", wcr.Quote(wcr.FromSlash(src)), wcr.Quote(wcr.FromSlash(dst))),
+	}
+}
+
 // MkDir implements Renderer.
 func (wcr *WinCmdRenderer) Mkdir(dirname string) []string {
 	dirname = wcr.Quote(dirname)
@@ -59,3 +167,46 @@ func (wcr *WinCmdRenderer) MkdirAll(dirname string) []string {
 func (wcr *WinCmdRenderer) ScriptFilename(name, dirname string) string {
 	return wcr.Join(dirname, name+".bat")
 }
+
+// Comment implements ControlRenderer.
+func (wcr *WinCmdRenderer) Comment(text string) []string {
+	return commentLines("rem ", text)
+}
+
+// If implements ControlRenderer.
+func (wcr *WinCmdRenderer) If(cond Condition, then, otherwise []string) []string {
+	path := wcr.FromSlash(cond.path)
+	if cond.kind == dirExists {
+		// Matching the directory's entries only succeeds for a directory.
+		path += `\*`
+	}
+	test := "exist " + wcr.Quote(path)
+	if cond.negate {
+		test = "not " + test
+	}
+	commands := []string{fmt.Sprintf("if %s (", test)}
+	commands = append(commands, then...)
+	if len(otherwise) > 0 {
+		commands = append(commands, ") else (")
+		commands = append(commands, otherwise...)
+	}
+	return append(commands, ")")
+}
+
+// ErrorHandling implements ControlRenderer. As cmd.exe cannot
+// handle errors implicitly, each command is followed by a check
+// of its exit code.
+func (wcr *WinCmdRenderer) ErrorHandling(mode ErrorMode, handler []string) ErrorHandling {
+	switch mode {
+	case ExitOnError:
+		return ErrorHandling{Check: []string{"if errorlevel 1 exit /b"}}
+	case TrapOnError:
+		epilogue := []string{"exit /b 0", ":on_error"}
+		epilogue = append(epilogue, handler...)
+		return ErrorHandling{
+			Check:    []string{"if errorlevel 1 goto on_error"},
+			Epilogue: append(epilogue, "exit /b 1"),
+		}
+	}
+	return ErrorHandling{}
+}
@@ -4,6 +4,7 @@
 package shell_test
 
 import (
+	"github.com/juju/errors"
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -52,9 +53,9 @@ func (s winCmdSuite) TestWriteFile(c *gc.C) {
 	commands := s.renderer.WriteFile(s.filename, data)
 
 	c.Check(commands, jc.DeepEquals, []string{
-		`>>^"C:\\some\\dir\\file^" @echo something`,
-		`>>^"C:\\some\\dir\\file^" @echo here`,
-		`>>^"C:\\some\\dir\\file^" @echo `,
+		`>>^"C:\some\dir\file^" @echo something`,
+		`>>^"C:\some\dir\file^" @echo here`,
+		`>>^"C:\some\dir\file^" @echo `,
 	})
 }
 
@@ -62,7 +63,7 @@ func (s winCmdSuite) TestMkdir(c *gc.C) {
 	commands := s.renderer.Mkdir(s.dirname)
 
 	c.Check(commands, jc.DeepEquals, []string{
-		`mkdir ^"C:\\some\\dir^"`,
+		`mkdir ^"C:\some\dir^"`,
 	})
 }
 
@@ -70,6 +71,109 @@ func (s winCmdSuite) TestMkdirAll(c *gc.C) {
 	commands := s.renderer.MkdirAll(s.dirname)
 
 	c.Check(commands, jc.DeepEquals, []string{
-		`mkdir ^"C:\\some\\dir^"`,
+		`mkdir ^"C:\some\dir^"`,
+	})
+}
+
+func (s winCmdSuite) TestAppendFile(c *gc.C) {
+	// Metacharacters and empty lines are appended exactly.
+	commands := s.renderer.AppendFile(s.filename, []byte("a & del C:\\ | b > c ^ %PATH%\n\n"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if exist ^"C:\some\dir\file.b64^" del /f /q ^"C:\some\dir\file.b64^"`,
+		`>>^"C:\some\dir\file.b64^" @echo YSAmIGRlbCBDOlwgfCBiID4gYyBeICVQQVRIJQoK`,
+		`certutil -f -decode ^"C:\some\dir\file.b64^" ^"C:\some\dir\file.new^" >nul`,
+		`del /f /q ^"C:\some\dir\file.b64^"`,
+		`if not exist ^"C:\some\dir\file^" move /y ^"C:\some\dir\file.new^" ^"C:\some\dir\file^" >nul`,
+		`if exist ^"C:\some\dir\file.new^" copy /b ^"C:\some\dir\file^"+^"C:\some\dir\file.new^" ^"C:\some\dir\file^" >nul`,
+		`if exist ^"C:\some\dir\file.new^" del /f /q ^"C:\some\dir\file.new^"`,
+	})
+}
+
+func (s winCmdSuite) TestAppendFileEmpty(c *gc.C) {
+	commands := s.renderer.AppendFile(s.filename, nil)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if not exist ^"C:\some\dir\file^" type nul > ^"C:\some\dir\file^"`,
+	})
+}
+
+func (s winCmdSuite) TestSetEnv(c *gc.C) {
+	commands, err := s.renderer.SetEnv("PATH", `C:\bin;%PATH%`)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`set "PATH=C:\bin;%%PATH%%"`,
+	})
+}
+
+func (s winCmdSuite) TestSetEnvInvalidName(c *gc.C) {
+	for _, name := range []string{"", "1A", "A-B", "A=B", `A" & del C:\ & "`} {
+		commands, err := s.renderer.SetEnv(name, "value")
+		c.Check(err, jc.Satisfies, errors.IsNotValid, gc.Commentf("name %q", name))
+		c.Check(commands, gc.HasLen, 0)
+	}
+}
+
+func (s winCmdSuite) TestSetEnvInvalidValue(c *gc.C) {
+	for _, value := range []string{`x" & del /q C:\ & "`, "x\r\ndel /q C:\\", "x\ny"} {
+		commands, err := s.renderer.SetEnv("NAME", value)
+		c.Check(err, jc.Satisfies, errors.IsNotValid, gc.Commentf("value %q", value))
+		c.Check(commands, gc.HasLen, 0)
+	}
+
+	// Other metacharacters are literal within the quotes.
+	commands, err := s.renderer.SetEnv("NAME", "a & b | c > d ^ e")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(commands, jc.DeepEquals, []string{
+		`set "NAME=a & b | c > d ^ e"`,
+	})
+}
+
+func (s winCmdSuite) TestRemove(c *gc.C) {
+	commands := s.renderer.Remove(s.filename)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if exist ^"C:\some\dir\file^" del /f /q ^"C:\some\dir\file^"`,
+	})
+}
+
+func (s winCmdSuite) TestSymlink(c *gc.C) {
+	commands := s.renderer.Symlink(s.filename, `C:\link`)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`mklink ^"C:\link^" ^"C:\some\dir\file^"`,
+	})
+}
+
+func (s winCmdSuite) TestCopy(c *gc.C) {
+	commands := s.renderer.Copy(s.filename, `C:\copy`)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`copy /y ^"C:\some\dir\file^" ^"C:\copy^"`,
+	})
+}
+
+func (s winCmdSuite) TestWriteFileIfChanged(c *gc.C) {
+	commands := s.renderer.WriteFileIfChanged(s.filename, []byte("abc"))
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if exist ^"C:\some\dir\file.b64^" del /f /q ^"C:\some\dir\file.b64^"`,
+		`>>^"C:\some\dir\file.b64^" @echo YWJj`,
+		`certutil -f -decode ^"C:\some\dir\file.b64^" ^"C:\some\dir\file.new^" >nul`,
+		`del /f /q ^"C:\some\dir\file.b64^"`,
+		`certutil -hashfile ^"C:\some\dir\file^" SHA256 2>nul | ` +
+			`findstr /x /i ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad >nul || ` +
+			`move /y ^"C:\some\dir\file.new^" ^"C:\some\dir\file^" >nul`,
+		`if exist ^"C:\some\dir\file.new^" del /f /q ^"C:\some\dir\file.new^"`,
+	})
+}
+
+func (s winCmdSuite) TestWriteFileIfChangedEmpty(c *gc.C) {
+	commands := s.renderer.WriteFileIfChanged(s.filename, nil)
+
+	c.Check(commands, jc.DeepEquals, []string{
+		`if not exist ^"C:\some\dir\file^" type nul > ^"C:\some\dir\file^"`,
+		`findstr /r "^" ^"C:\some\dir\file^" >nul 2>&1 && type nul > ^"C:\some\dir\file^"`,
 	})
 }
@@ -0,0 +1,62 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package shell
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// base64LineLength is the length of the lines that
+// base64 encoded file content is split into.
+const base64LineLength = 76
+
+// sha256Hex returns the hex encoded SHA256 checksum of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// base64Lines returns data base64 encoded and split into lines.
+func base64Lines(data []byte) []string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var lines []string
+	for len(encoded) > base64LineLength {
+		lines = append(lines, encoded[:base64LineLength])
+		encoded = encoded[base64LineLength:]
+	}
+	return append(lines, encoded)
+}
+
+// heredocDelimiter returns the delimiter for a heredoc holding
+// data. It is "EOF", unless a line of data would end the heredoc
+// early, in which case a numeric suffix is added.
+func heredocDelimiter(data []byte) string {
+	lines := strings.Split(string(data), "\n")
+	delimiter := "EOF"
+	for i := 1; containsString(lines, delimiter); i++ {
+		delimiter = "EOF" + strconv.Itoa(i)
+	}
+	return delimiter
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether data can be written as shell text,
+// rather than needing to be encoded.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
@@ -0,0 +1,161 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// AuditEvent describes a command executed on a remote host. For a
+// copy made with Client.Copy, Command holds "scp" followed by the
+// arguments, and Host and User are taken from the first remote
+// file named in them.
+type AuditEvent struct {
+	// Host is the host the command is executed on.
+	Host string
+
+	// User is the user the command is executed as,
+	// or empty if no user was specified.
+	User string
+
+	// Command holds the command and its arguments.
+	Command []string
+
+	// Start is the time at which the command was started.
+	Start time.Time
+
+	// Duration is how long the command took to run. It is
+	// only set when the command has finished.
+	Duration time.Duration
+
+	// ExitCode is the exit code of the command, or -1 if the
+	// command did not exit normally. It is only set when the
+	// command has finished.
+	ExitCode int
+
+	// Err is the error the command finished with, if any.
+	Err error
+}
+
+// AuditHook is notified of commands executed by Cmd, and of copies
+// made with Client.Copy, whichever Client implementation is used.
+// A hook is set for all commands using SetDefaultAuditHook, or for
+// those created with some Options using Options.SetAuditHook.
+type AuditHook interface {
+	// CommandStarted is called before a command is started.
+	CommandStarted(event AuditEvent)
+
+	// CommandFinished is called when a command has finished,
+	// or could not be started.
+	CommandFinished(event AuditEvent)
+}
+
+// audit records the details of a command for the audit hook.
+type audit struct {
+	hook  AuditHook
+	event AuditEvent
+
+	// running is set while the hook has been notified that
+	// the command started, but not that it has finished.
+	running bool
+}
+
+var defaultAuditHook struct {
+	sync.Mutex
+	hook AuditHook
+}
+
+// SetDefaultAuditHook sets the hook notified of commands executed
+// with Options that have no audit hook of their own, or with nil
+// Options. If hook is nil, such commands are not audited.
+func SetDefaultAuditHook(hook AuditHook) {
+	defaultAuditHook.Lock()
+	defer defaultAuditHook.Unlock()
+	defaultAuditHook.hook = hook
+}
+
+// newAudit returns an audit for the command with the specified
+// user, host and arguments, notifying the audit hook in options,
+// or the default audit hook if there is none.
+func newAudit(user, host string, command []string, options *Options) audit {
+	var hook AuditHook
+	if options != nil {
+		hook = options.auditHook
+	}
+	if hook == nil {
+		defaultAuditHook.Lock()
+		hook = defaultAuditHook.hook
+		defaultAuditHook.Unlock()
+	}
+	return audit{
+		hook: hook,
+		event: AuditEvent{
+			Host:    host,
+			User:    user,
+			Command: command,
+		},
+	}
+}
+
+// newCopyAudit returns an audit for a copy made with the
+// specified scp arguments, as for newAudit.
+func newCopyAudit(args []string, options *Options) audit {
+	var user, host string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		// Local paths may contain a colon, but only after a slash.
+		i := strings.Index(arg, ":")
+		if i <= 0 || strings.Contains(arg[:i], "/") {
+			continue
+		}
+		user, host = splitUserHost(arg[:i])
+		break
+	}
+	command := append([]string{"scp"}, args...)
+	return newAudit(user, host, command, options)
+}
+
+// started notifies the audit hook, if any, that
+// the command is about to start.
+func (a *audit) started() {
+	if a.hook == nil {
+		return
+	}
+	a.running = true
+	a.event.Start = clock.WallClock.Now()
+	a.hook.CommandStarted(a.event)
+}
+
+// finished notifies the audit hook, if it was notified by started,
+// that the command has finished with the given error. Only the
+// first call has any effect.
+func (a *audit) finished(err error) {
+	if !a.running {
+		return
+	}
+	a.running = false
+	event := a.event
+	event.Duration = clock.WallClock.Now().Sub(event.Start)
+	event.ExitCode = exitCode(err)
+	event.Err = err
+	a.hook.CommandFinished(event)
+}
+
+// exitCode returns the exit code of a command
+// that finished with the given error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*ExitError); ok {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
@@ -0,0 +1,223 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh
+
+import (
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Names of commonly used authorized_keys options.
+// See "AUTHORIZED_KEYS FILE FORMAT" in "man sshd".
+const (
+	OptionCommand           = "command"
+	OptionEnvironment       = "environment"
+	OptionExpiryTime        = "expiry-time"
+	OptionFrom              = "from"
+	OptionPermitOpen        = "permitopen"
+	OptionPermitListen      = "permitlisten"
+	OptionPrincipals        = "principals"
+	OptionTunnel            = "tunnel"
+	OptionNoAgentForwarding = "no-agent-forwarding"
+	OptionNoPortForwarding  = "no-port-forwarding"
+	OptionNoPTY             = "no-pty"
+	OptionNoUserRC          = "no-user-rc"
+	OptionNoX11Forwarding   = "no-X11-forwarding"
+	OptionRestrict          = "restrict"
+)
+
+// Layouts accepted for expiry-time values, which may
+// also be followed by "Z" to indicate UTC.
+const (
+	expiryTimeLayoutDays    = "20060102"
+	expiryTimeLayoutMinutes = "200601021504"
+	expiryTimeLayoutSeconds = "20060102150405"
+)
+
+// valueOptions holds the names of the options that always take a
+// value, so that they are written as name="value" even if the value
+// is empty.
+var valueOptions = map[string]bool{
+	OptionCommand:      true,
+	OptionEnvironment:  true,
+	OptionExpiryTime:   true,
+	OptionFrom:         true,
+	OptionPermitOpen:   true,
+	OptionPermitListen: true,
+	OptionPrincipals:   true,
+	OptionTunnel:       true,
+}
+
+// AuthorisedKeyOption is an option that restricts or enables
+// features for a key in an authorized_keys file, such as
+// no-pty or command="/usr/bin/backup".
+type AuthorisedKeyOption struct {
+	// Name is the name of the option, such as "no-pty".
+	Name string
+
+	// Value is the unquoted value of the option, for options
+	// written as name="value". It is empty for flag options.
+	Value string
+}
+
+// String returns the option as it appears in an authorized_keys file.
+// Double quotes in the value are escaped with a backslash; as sshd
+// unescapes nothing else, other backslashes are written as they are.
+// The option should be validated first, as a newline in the option
+// would end the line, and a trailing backslash would escape the
+// closing quote.
+func (o AuthorisedKeyOption) String() string {
+	if o.Value == "" && !valueOptions[strings.ToLower(o.Name)] {
+		return o.Name
+	}
+	return o.Name + `="` + strings.Replace(o.Value, `"`, `\"`, -1) + `"`
+}
+
+// Validate returns an error satisfying errors.IsNotValid if the
+// option cannot be written to an authorized_keys file.
+func (o AuthorisedKeyOption) Validate() error {
+	if strings.Contains(o.Name, "\n") {
+		return errors.NotValidf("newline in option name %q", o.Name)
+	}
+	if strings.Contains(o.Value, "\n") {
+		return errors.NotValidf("newline in %s option value %q", o.Name, o.Value)
+	}
+	if strings.HasSuffix(o.Value, `\`) {
+		// Parsers of authorized_keys files treat any quote
+		// following a backslash as escaped, so the value
+		// cannot be terminated.
+		return errors.NotValidf("%s option value %q ending with a backslash", o.Name, o.Value)
+	}
+	return nil
+}
+
+// parseAuthorisedKeyOption parses a single option, as returned by
+// ssh.ParseAuthorizedKey.
+func parseAuthorisedKeyOption(option string) AuthorisedKeyOption {
+	name, value, ok := strings.Cut(option, "=")
+	if !ok {
+		return AuthorisedKeyOption{Name: option}
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	value = strings.Replace(value, `\"`, `"`, -1)
+	return AuthorisedKeyOption{Name: name, Value: value}
+}
+
+// FlagOption returns an option without a value,
+// such as OptionNoPortForwarding.
+func FlagOption(name string) AuthorisedKeyOption {
+	return AuthorisedKeyOption{Name: name}
+}
+
+// CommandOption returns an option forcing the given command to be
+// run whenever the key is used for authentication.
+func CommandOption(command string) AuthorisedKeyOption {
+	return AuthorisedKeyOption{Name: OptionCommand, Value: command}
+}
+
+// FromOption returns an option restricting use of the key to clients
+// whose host name or address matches one of the given patterns.
+func FromOption(patterns ...string) AuthorisedKeyOption {
+	return AuthorisedKeyOption{Name: OptionFrom, Value: strings.Join(patterns, ",")}
+}
+
+// ExpiryTimeOption returns an option preventing the key from
+// being used after the given time.
+func ExpiryTimeOption(t time.Time) AuthorisedKeyOption {
+	return AuthorisedKeyOption{
+		Name:  OptionExpiryTime,
+		Value: t.UTC().Format(expiryTimeLayoutSeconds) + "Z",
+	}
+}
+
+// AuthorisedKeyOptions holds the options for a key in an
+// authorized_keys file, in the order in which they appear.
+type AuthorisedKeyOptions []AuthorisedKeyOption
+
+// String returns the options as they appear in an authorized_keys file.
+func (opts AuthorisedKeyOptions) String() string {
+	parts := make([]string, len(opts))
+	for i, opt := range opts {
+		parts[i] = opt.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Validate returns an error satisfying errors.IsNotValid if
+// any of the options cannot be written to an authorized_keys file.
+func (opts AuthorisedKeyOptions) Validate() error {
+	for _, opt := range opts {
+		if err := opt.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the first option with the given name,
+// and whether any such option exists. Option names are not case
+// sensitive.
+func (opts AuthorisedKeyOptions) Get(name string) (string, bool) {
+	for _, opt := range opts {
+		if strings.EqualFold(opt.Name, name) {
+			return opt.Value, true
+		}
+	}
+	return "", false
+}
+
+// Has reports whether an option with the given name exists.
+func (opts AuthorisedKeyOptions) Has(name string) bool {
+	_, ok := opts.Get(name)
+	return ok
+}
+
+// Command returns the forced command for the key, if any.
+func (opts AuthorisedKeyOptions) Command() (string, bool) {
+	return opts.Get(OptionCommand)
+}
+
+// From returns the patterns restricting the clients
+// that may use the key, if any.
+func (opts AuthorisedKeyOptions) From() []string {
+	value, ok := opts.Get(OptionFrom)
+	if !ok {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// ExpiryTime returns the time after which the key may no longer be
+// used. If the key has no expiry time, an error satisfying
+// errors.IsNotFound is returned. Times without a trailing "Z" are
+// interpreted in the local time zone, as sshd does.
+func (opts AuthorisedKeyOptions) ExpiryTime() (time.Time, error) {
+	value, ok := opts.Get(OptionExpiryTime)
+	if !ok {
+		return time.Time{}, errors.NotFoundf("expiry time")
+	}
+	location := time.Local
+	if strings.HasSuffix(value, "Z") {
+		location = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+	for _, layout := range []string{
+		expiryTimeLayoutDays,
+		expiryTimeLayoutMinutes,
+		expiryTimeLayoutSeconds,
+	} {
+		if len(value) == len(layout) {
+			t, err := time.ParseInLocation(layout, value, location)
+			if err != nil {
+				break
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.NotValidf("expiry time %q", value)
+}
@@ -0,0 +1,201 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh_test
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	gitjujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/ssh"
+	sshtesting "github.com/juju/utils/v4/ssh/testing"
+)
+
+type AuthorisedKeyOptionsSuite struct {
+	gitjujutesting.FakeHomeSuite
+}
+
+var _ = gc.Suite(&AuthorisedKeyOptionsSuite{})
+
+func (s *AuthorisedKeyOptionsSuite) TestParseOptions(c *gc.C) {
+	line := `no-pty,command="echo \"hi\"",from="10.0.0.0/8,!10.1.2.3",expiry-time="20300102Z" ` +
+		sshtesting.ValidKeyOne.Key + " backup@host"
+	ak, err := ssh.ParseAuthorisedKey(line)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(ak.Options, jc.DeepEquals, ssh.AuthorisedKeyOptions{
+		{Name: "no-pty"},
+		{Name: "command", Value: `echo "hi"`},
+		{Name: "from", Value: "10.0.0.0/8,!10.1.2.3"},
+		{Name: "expiry-time", Value: "20300102Z"},
+	})
+	c.Assert(ak.Comment, gc.Equals, "backup@host")
+
+	command, ok := ak.Options.Command()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(command, gc.Equals, `echo "hi"`)
+	c.Assert(ak.Options.From(), jc.DeepEquals, []string{"10.0.0.0/8", "!10.1.2.3"})
+	c.Assert(ak.Options.Has(ssh.OptionNoPTY), jc.IsTrue)
+	c.Assert(ak.Options.Has(ssh.OptionNoPortForwarding), jc.IsFalse)
+	expiry, err := ak.Options.ExpiryTime()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(expiry, gc.Equals, time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC))
+
+	// The line round-trips without losing any options.
+	c.Assert(ak.String(), gc.Equals, line)
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestParseNoOptions(c *gc.C) {
+	ak, err := ssh.ParseAuthorisedKey(sshtesting.ValidKeyOne.Key)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(ak.Options, gc.IsNil)
+	c.Assert(ak.String(), gc.Equals, sshtesting.ValidKeyOne.Key)
+	_, ok := ak.Options.Command()
+	c.Assert(ok, jc.IsFalse)
+	c.Assert(ak.Options.From(), gc.IsNil)
+	_, err = ak.Options.ExpiryTime()
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestOptionString(c *gc.C) {
+	for i, test := range []struct {
+		option   ssh.AuthorisedKeyOption
+		expected string
+	}{{
+		option:   ssh.FlagOption(ssh.OptionNoPortForwarding),
+		expected: "no-port-forwarding",
+	}, {
+		option:   ssh.CommandOption(`/usr/bin/backup --name "daily"`),
+		expected: `command="/usr/bin/backup --name \"daily\""`,
+	}, {
+		option:   ssh.CommandOption(""),
+		expected: `command=""`,
+	}, {
+		option:   ssh.FromOption("*.example.com", "192.168.0.1"),
+		expected: `from="*.example.com,192.168.0.1"`,
+	}, {
+		option:   ssh.ExpiryTimeOption(time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))),
+		expected: `expiry-time="20300102020405Z"`,
+	}, {
+		option:   ssh.AuthorisedKeyOption{Name: "environment", Value: "FOO=bar"},
+		expected: `environment="FOO=bar"`,
+	}, {
+		option:   ssh.CommandOption(`printf '%s\n' "a\\b" C:\dir`),
+		expected: `command="printf '%s\n' \"a\\b\" C:\dir"`,
+	}, {
+		option:   ssh.CommandOption(`\"`),
+		expected: `command="\\""`,
+	}} {
+		c.Logf("test %d: %s", i, test.expected)
+		c.Check(test.option.String(), gc.Equals, test.expected)
+		c.Check(test.option.Validate(), jc.ErrorIsNil)
+	}
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestOptionRoundTrip(c *gc.C) {
+	for i, value := range []string{
+		`echo "hi"`,
+		`C:\dir`,
+		`\"`,
+		`a\\"b`,
+	} {
+		c.Logf("test %d: %s", i, value)
+		key := &ssh.AuthorisedKey{
+			Options: ssh.AuthorisedKeyOptions{ssh.CommandOption(value)},
+		}
+		line := key.Options.String() + " " + sshtesting.ValidKeyOne.Key
+		ak, err := ssh.ParseAuthorisedKey(line)
+		c.Assert(err, jc.ErrorIsNil)
+		command, ok := ak.Options.Command()
+		c.Check(ok, jc.IsTrue)
+		c.Check(command, gc.Equals, value)
+	}
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestOptionValidateNewline(c *gc.C) {
+	err := ssh.CommandOption("true\nssh-ed25519 AAAA attacker").Validate()
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, `newline in command option value .* not valid`)
+
+	opts := ssh.AuthorisedKeyOptions{
+		ssh.FlagOption(ssh.OptionNoPTY),
+		{Name: "no-pty\nssh-ed25519"},
+	}
+	err = opts.Validate()
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, `newline in option name .* not valid`)
+	c.Assert(opts[:1].Validate(), jc.ErrorIsNil)
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestOptionValidateTrailingBackslash(c *gc.C) {
+	// The closing quote would be read as escaped.
+	err := ssh.CommandOption(`C:\`).Validate()
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, `command option value .* ending with a backslash not valid`)
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestExpiryTime(c *gc.C) {
+	for i, test := range []struct {
+		value    string
+		expected time.Time
+		err      string
+	}{{
+		value:    "20300102",
+		expected: time.Date(2030, 1, 2, 0, 0, 0, 0, time.Local),
+	}, {
+		value:    "203001020304",
+		expected: time.Date(2030, 1, 2, 3, 4, 0, 0, time.Local),
+	}, {
+		value:    "20300102030405Z",
+		expected: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, {
+		value: "2030",
+		err:   `expiry time "2030" not valid`,
+	}, {
+		value: "20301302",
+		err:   `expiry time "20301302" not valid`,
+	}} {
+		c.Logf("test %d: %s", i, test.value)
+		opts := ssh.AuthorisedKeyOptions{{Name: ssh.OptionExpiryTime, Value: test.value}}
+		t, err := opts.ExpiryTime()
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(t.Equal(test.expected), jc.IsTrue)
+	}
+}
+
+func (s *AuthorisedKeyOptionsSuite) TestAddRestrictedKey(c *gc.C) {
+	ak, err := ssh.ParseAuthorisedKey(sshtesting.ValidKeyOne.Key + " backup@host")
+	c.Assert(err, jc.ErrorIsNil)
+	ak.Options = ssh.AuthorisedKeyOptions{
+		ssh.CommandOption("/usr/local/bin/backup"),
+		ssh.FromOption("10.0.0.1"),
+		ssh.FlagOption(ssh.OptionNoPortForwarding),
+		ssh.FlagOption(ssh.OptionNoPTY),
+	}
+	err = ssh.AddKeys(testSSHUser, ak.String())
+	c.Assert(err, jc.ErrorIsNil)
+
+	keys, err := ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(keys, jc.DeepEquals, []string{
+		`command="/usr/local/bin/backup",from="10.0.0.1",no-port-forwarding,no-pty ` +
+			sshtesting.ValidKeyOne.Key + " backup@host",
+	})
+
+	// Replacing the keys keeps the options of the new keys.
+	ak.Options = ak.Options[:1]
+	err = ssh.ReplaceKeys(testSSHUser, ak.String())
+	c.Assert(err, jc.ErrorIsNil)
+	keys, err = ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(keys, jc.DeepEquals, []string{
+		`command="/usr/local/bin/backup" ` + sshtesting.ValidKeyOne.Key + " backup@host",
+	})
+}
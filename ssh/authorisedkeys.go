@@ -4,6 +4,10 @@
 package ssh
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -13,9 +17,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/juju/clock"
 	"github.com/juju/errors"
 	"github.com/juju/loggo/v2"
+	"github.com/juju/mutex/v2"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/juju/utils/v4"
@@ -34,12 +41,29 @@ const (
 	defaultAuthKeysFile = "authorized_keys"
 )
 
+// AuthorisedKey represents a line of an authorized_keys file.
 type AuthorisedKey struct {
+	Options AuthorisedKeyOptions
 	Type    string
 	Key     []byte
 	Comment string
 }
 
+// String returns the key as a line in authorized_keys format,
+// including any options and comment. Keys with options may be
+// passed to AddKeys or ReplaceKeys in this format, once the
+// options have been validated.
+func (k *AuthorisedKey) String() string {
+	line := k.Type + " " + base64.StdEncoding.EncodeToString(k.Key)
+	if len(k.Options) > 0 {
+		line = k.Options.String() + " " + line
+	}
+	if k.Comment != "" {
+		line += " " + k.Comment
+	}
+	return line
+}
+
 func authKeysDir(username string) (string, error) {
 	homeDir, err := utils.UserHomeDir(username)
 	if err != nil {
@@ -59,11 +83,16 @@ func ParseAuthorisedKey(line string) (*AuthorisedKey, error) {
 	if strings.Contains(line, "\n") {
 		return nil, errors.NotValidf("newline in authorized_key %q", line)
 	}
-	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
 	if err != nil {
 		return nil, errors.Errorf("invalid authorized_key %q", line)
 	}
+	var keyOptions AuthorisedKeyOptions
+	for _, option := range options {
+		keyOptions = append(keyOptions, parseAuthorisedKeyOption(option))
+	}
 	return &AuthorisedKey{
+		Options: keyOptions,
 		Type:    key.Type(),
 		Key:     key.Marshal(),
 		Comment: comment,
@@ -103,19 +132,33 @@ func SplitAuthorisedKeys(keyData string) []string {
 	return keys
 }
 
-func readAuthorisedKeys(username, filename string) ([]string, error) {
+func authorisedKeysPath(username, filename string) (string, error) {
 	keyDir, err := authKeysDir(username)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(keyDir, filename), nil
+}
+
+func readAuthorisedKeys(username, filename string) ([]string, error) {
+	sshKeyFile, err := authorisedKeysPath(username, filename)
 	if err != nil {
 		return nil, err
 	}
-	sshKeyFile := filepath.Join(keyDir, filename)
+	_, keys, err := readAuthorisedKeysFile(sshKeyFile)
+	return keys, err
+}
+
+// readAuthorisedKeysFile returns the raw contents of the specified
+// authorized_keys file, along with the non-blank lines it contains.
+func readAuthorisedKeysFile(sshKeyFile string) ([]byte, []string, error) {
 	logger.Debugf("reading authorised keys file %s", sshKeyFile)
 	keyData, err := ioutil.ReadFile(sshKeyFile)
 	if os.IsNotExist(err) {
-		return []string{}, nil
+		return nil, []string{}, nil
 	}
 	if err != nil {
-		return nil, errors.Annotate(err, "reading ssh authorised keys file")
+		return nil, nil, errors.Annotate(err, "reading ssh authorised keys file")
 	}
 	var keys []string
 	for _, key := range strings.Split(string(keyData), "\n") {
@@ -124,7 +167,7 @@ func readAuthorisedKeys(username, filename string) ([]string, error) {
 		}
 		keys = append(keys, key)
 	}
-	return keys, nil
+	return keyData, keys, nil
 }
 
 func writeAuthorisedKeys(username, filename string, keys []string) error {
@@ -186,53 +229,128 @@ func writeAuthorisedKeys(username, filename string, keys []string) error {
 
 // We need a mutex because updates to the authorised keys file are done by
 // reading the contents, updating, and writing back out. So only one caller
-// at a time can use either Add, Delete, List.
+// at a time can use either Add, Delete, List. Updates are additionally
+// serialised with other processes by acquireAuthorisedKeysLock.
 var keysMutex sync.Mutex
 
-// AddKeys adds the specified ssh keys to the authorized_keys file for user.
-// Returns an error if there is an issue with *any* of the supplied keys.
-func AddKeys(user string, newKeys ...string) error {
-	keysMutex.Lock()
-	defer keysMutex.Unlock()
-	existingKeys, err := readAuthorisedKeys(user, defaultAuthKeysFile)
+// ErrConcurrentUpdate is returned when an authorised keys file is changed
+// by another writer, not holding the update lock, while it is being updated.
+var ErrConcurrentUpdate = errors.New("authorised keys file changed during update")
+
+// authorisedKeysLockDelay is the time to wait between
+// attempts to acquire an authorised keys file lock.
+const authorisedKeysLockDelay = 100 * time.Millisecond
+
+// authorisedKeysLockTimeout is the time to wait for an authorised
+// keys file lock before giving up. It is a variable so that tests
+// can shorten it.
+var authorisedKeysLockTimeout = time.Minute
+
+// acquireAuthorisedKeysLock acquires an inter-process lock for
+// updating the specified authorised keys file. If the lock cannot
+// be acquired within authorisedKeysLockTimeout, an error satisfying
+// errors.Is(err, mutex.ErrTimeout) is returned.
+func acquireAuthorisedKeysLock(sshKeyFile string) (mutex.Releaser, error) {
+	// Lock names are limited in length and character set,
+	// so are derived from a hash of the file's path.
+	hash := sha256.Sum256([]byte(sshKeyFile))
+	releaser, err := mutex.Acquire(mutex.Spec{
+		Name:    "juju-authkeys-" + hex.EncodeToString(hash[:12]),
+		Clock:   clock.WallClock,
+		Delay:   authorisedKeysLockDelay,
+		Timeout: authorisedKeysLockTimeout,
+	})
+	if errors.Is(err, mutex.ErrTimeout) {
+		return nil, errors.Annotatef(err,
+			"another process held the lock for %s for more than %v", sshKeyFile, authorisedKeysLockTimeout)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "locking %s", sshKeyFile)
+	}
+	return releaser, nil
+}
+
+// beforeAuthorisedKeysWrite is called just before checking for
+// concurrent changes and writing an updated authorised keys file.
+// It exists so that tests can simulate concurrent writers.
+var beforeAuthorisedKeysWrite = func(sshKeyFile string) {}
+
+// updateAuthorisedKeys reads the lines of the specified authorised keys
+// file, and writes back the lines returned by update. The file is locked
+// against updates by other processes throughout. If the file is changed
+// by a writer not holding the lock, an error satisfying
+// errors.Is(err, ErrConcurrentUpdate) is returned and nothing is written.
+func updateAuthorisedKeys(username, filename string, update func(existingKeys []string) ([]string, error)) error {
+	sshKeyFile, err := authorisedKeysPath(username, filename)
 	if err != nil {
 		return err
 	}
-	return addKeys(user, defaultAuthKeysFile, newKeys, existingKeys)
+	releaser, err := acquireAuthorisedKeysLock(sshKeyFile)
+	if err != nil {
+		return err
+	}
+	defer releaser.Release()
+
+	keyData, existingKeys, err := readAuthorisedKeysFile(sshKeyFile)
+	if err != nil {
+		return err
+	}
+	keys, err := update(existingKeys)
+	if err != nil {
+		return err
+	}
+
+	beforeAuthorisedKeysWrite(sshKeyFile)
+	currentKeyData, err := ioutil.ReadFile(sshKeyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Annotate(err, "reading ssh authorised keys file")
+	}
+	if !bytes.Equal(currentKeyData, keyData) {
+		return errors.Annotatef(ErrConcurrentUpdate, "updating %s", sshKeyFile)
+	}
+	return writeAuthorisedKeys(username, filename, keys)
+}
+
+// AddKeys adds the specified ssh keys to the authorized_keys file for user.
+// Keys may be preceded by options restricting their use; see AuthorisedKey.
+// Returns an error if there is an issue with *any* of the supplied keys.
+func AddKeys(user string, newKeys ...string) error {
+	return AddKeysToFile(user, defaultAuthKeysFile, newKeys)
 }
 
 // DeleteKeys removes the specified ssh keys from the authorized ssh keys file for user.
-// keyIds may be either key comments or fingerprints.
+// keyIds may be either key comments or fingerprints, in either the MD5 or SHA256 format.
 // Returns an error if there is an issue with *any* of the keys to delete.
 func DeleteKeys(user string, keyIds ...string) error {
 	keysMutex.Lock()
 	defer keysMutex.Unlock()
-	existingKeys, err := readAuthorisedKeys(user, defaultAuthKeysFile)
-	if err != nil {
-		return err
-	}
-	return deleteKeys(user, defaultAuthKeysFile, existingKeys, keyIds, false)
+	return updateAuthorisedKeys(user, defaultAuthKeysFile, func(existingKeys []string) ([]string, error) {
+		return deleteKeys(existingKeys, keyIds, false)
+	})
 }
 
 // ReplaceKeys writes the specified ssh keys to the authorized_keys file for user,
-// replacing any that are already there.
+// replacing any that are already there. Keys may be preceded by options
+// restricting their use; see AuthorisedKey.
 // Returns an error if there is an issue with *any* of the supplied keys.
 func ReplaceKeys(user string, newKeys ...string) error {
+	for _, key := range newKeys {
+		if strings.Contains(key, "\n") {
+			return errors.NotValidf("newline in authorized_key %q", key)
+		}
+	}
 	keysMutex.Lock()
 	defer keysMutex.Unlock()
-
-	existingKeyData, err := readAuthorisedKeys(user, defaultAuthKeysFile)
-	if err != nil {
-		return err
-	}
-	var existingNonKeyLines []string
-	for _, line := range existingKeyData {
-		_, _, err := KeyFingerprint(line)
-		if err != nil {
-			existingNonKeyLines = append(existingNonKeyLines, line)
+	return updateAuthorisedKeys(user, defaultAuthKeysFile, func(existingKeyData []string) ([]string, error) {
+		var existingNonKeyLines []string
+		for _, line := range existingKeyData {
+			_, _, err := KeyFingerprint(line)
+			if err != nil {
+				existingNonKeyLines = append(existingNonKeyLines, line)
+			}
 		}
-	}
-	return writeAuthorisedKeys(user, defaultAuthKeysFile, append(existingNonKeyLines, newKeys...))
+		return append(existingNonKeyLines, newKeys...), nil
+	})
 }
 
 // ListKeys returns either the full keys or key comments from the authorized ssh keys file for user.
@@ -275,26 +393,22 @@ func EnsureJujuComment(key string) string {
 func AddKeysToFile(user, file string, newKeys []string) error {
 	keysMutex.Lock()
 	defer keysMutex.Unlock()
-	existingKeys, err := readAuthorisedKeys(user, file)
-	if err != nil {
-		return err
-	}
-	return addKeys(user, file, newKeys, existingKeys)
+	return updateAuthorisedKeys(user, file, func(existingKeys []string) ([]string, error) {
+		return addKeys(newKeys, existingKeys)
+	})
 }
 
 // DeleteKeysFromFile removes the specified ssh keys from the authorized ssh keys file for user.
-// keyIds may be either key comments or fingerprints.
+// keyIds may be either key comments or fingerprints, in either the MD5 or SHA256 format.
 // Returns an error if there is an issue with *any* of the keys to delete.
 //
 // Unlike DeleteKeys, this version can delete ALL keys from the target file.
 func DeleteKeysFromFile(user, file string, keyIds []string) error {
 	keysMutex.Lock()
 	defer keysMutex.Unlock()
-	existingKeys, err := readAuthorisedKeys(user, file)
-	if err != nil {
-		return err
-	}
-	return deleteKeys(user, file, existingKeys, keyIds, true)
+	return updateAuthorisedKeys(user, file, func(existingKeys []string) ([]string, error) {
+		return deleteKeys(existingKeys, keyIds, true)
+	})
 }
 
 // ListKeys returns either the full keys or key comments from the authorized ssh keys file for user.
@@ -308,14 +422,14 @@ func ListKeysFromFile(user, file string, mode ListMode) ([]string, error) {
 	return listKeys(keyData, mode)
 }
 
-func addKeys(user, file string, newKeys, existingKeys []string) error {
+func addKeys(newKeys, existingKeys []string) ([]string, error) {
 	for _, newKey := range newKeys {
 		fingerprint, comment, err := KeyFingerprint(newKey)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if comment == "" {
-			return errors.Errorf("cannot add ssh key without comment")
+			return nil, errors.Errorf("cannot add ssh key without comment")
 		}
 		for _, key := range existingKeys {
 			existingFingerprint, existingComment, err := KeyFingerprint(key)
@@ -327,46 +441,53 @@ func addKeys(user, file string, newKeys, existingKeys []string) error {
 				continue
 			}
 			if existingFingerprint == fingerprint {
-				return errors.Errorf("cannot add duplicate ssh key: %v", fingerprint)
+				return nil, errors.Errorf("cannot add duplicate ssh key: %v", fingerprint)
 			}
 			if existingComment == comment {
-				return errors.Errorf("cannot add ssh key with duplicate comment: %v", comment)
+				return nil, errors.Errorf("cannot add ssh key with duplicate comment: %v", comment)
 			}
 		}
 	}
-	sshKeys := append(existingKeys, newKeys...)
-	return writeAuthorisedKeys(user, file, sshKeys)
+	return append(existingKeys, newKeys...), nil
 }
 
-func deleteKeys(user, file string, existingKeys, keyIdsToDelete []string, deleteAll bool) error {
+func deleteKeys(existingKeys, keyIdsToDelete []string, deleteAll bool) ([]string, error) {
 	// Build up a map of keys indexed by fingerprint, and fingerprints indexed by comment
-	// so we can easily get the key represented by each keyId, which may be either a fingerprint
-	// or comment.
+	// and SHA256 fingerprint, so we can easily get the key represented by each keyId,
+	// which may be either a fingerprint in either format or a comment.
 	var keysToWrite []string
 	var sshKeys = make(map[string]string)
 	var keyComments = make(map[string]string)
+	var sha256Fingerprints = make(map[string]string)
 	for _, key := range existingKeys {
-		fingerprint, comment, err := KeyFingerprint(key)
+		info, err := ParseKeyInfo(key)
 		if err != nil {
 			logger.Debugf("keeping unrecognised existing ssh key %q: %v", key, err)
 			keysToWrite = append(keysToWrite, key)
 			continue
 		}
+		fingerprint := info.MD5Fingerprint
 		sshKeys[fingerprint] = key
-		if comment != "" {
-			keyComments[comment] = fingerprint
+		sha256Fingerprints[info.SHA256Fingerprint] = fingerprint
+		if info.Comment != "" {
+			keyComments[info.Comment] = fingerprint
 		}
 	}
 	for _, keyId := range keyIdsToDelete {
 		// assume keyId may be a fingerprint
-		fingerprint := keyId
-		_, ok := sshKeys[keyId]
+		fingerprint, ok := normaliseFingerprint(keyId)
+		if md5Fingerprint, found := sha256Fingerprints[fingerprint]; found {
+			fingerprint = md5Fingerprint
+		}
+		if ok {
+			_, ok = sshKeys[fingerprint]
+		}
 		if !ok {
 			// keyId is a comment
 			fingerprint, ok = keyComments[keyId]
 		}
 		if !ok {
-			return errors.Errorf("cannot delete non existent key: %v", keyId)
+			return nil, errors.Errorf("cannot delete non existent key: %v", keyId)
 		}
 		delete(sshKeys, fingerprint)
 	}
@@ -374,9 +495,9 @@ func deleteKeys(user, file string, existingKeys, keyIdsToDelete []string, delete
 		keysToWrite = append(keysToWrite, key)
 	}
 	if len(keysToWrite) == 0 && !deleteAll {
-		return errors.Errorf("cannot delete all keys")
+		return nil, errors.Errorf("cannot delete all keys")
 	}
-	return writeAuthorisedKeys(user, file, keysToWrite)
+	return keysToWrite, nil
 }
 
 func listKeys(existingKeys []string, mode ListMode) ([]string, error) {
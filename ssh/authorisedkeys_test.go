@@ -5,8 +5,14 @@ package ssh_test
 
 import (
 	"encoding/base64"
+	"fmt"
+	"io/ioutil"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/juju/errors"
+	"github.com/juju/mutex/v2"
 	gitjujutesting "github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -136,6 +142,24 @@ func (s *AuthorisedKeysKeysSuite) TestDeleteKeys(c *gc.C) {
 	c.Assert(actual, gc.DeepEquals, []string{thirdKey})
 }
 
+func (s *AuthorisedKeysKeysSuite) TestDeleteKeysByFingerprintFormat(c *gc.C) {
+	firstKey := sshtesting.ValidKeyOne.Key + " user@host"
+	anotherKey := sshtesting.ValidKeyTwo.Key
+	thirdKey := sshtesting.ValidKeyThree.Key + " anotheruser@host"
+	writeAuthKeysFile(c, []string{firstKey, anotherKey, thirdKey}, authKeysFile)
+	err := ssh.DeleteKeys(testSSHUser,
+		"SHA256:o4mn2Zj6qXG0gDlan4PqSrz9/5bt3OQvsUZADv4KXu0",
+		"MD5:"+sshtesting.ValidKeyTwo.Fingerprint,
+	)
+	c.Assert(err, jc.ErrorIsNil)
+	actual, err := ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(actual, gc.DeepEquals, []string{thirdKey})
+
+	err = ssh.DeleteKeys(testSSHUser, "SHA256:o4mn2Zj6qXG0gDlan4PqSrz9/5bt3OQvsUZADv4KXu0")
+	c.Assert(err, gc.ErrorMatches, "cannot delete non existent key: SHA256:.*")
+}
+
 func (s *AuthorisedKeysKeysSuite) TestDeleteKeysKeepsUnrecognised(c *gc.C) {
 	firstKey := sshtesting.ValidKeyOne.Key + " user@host"
 	writeAuthKeysFile(c, []string{firstKey, sshtesting.ValidKeyTwo.Key, "invalid-key"}, authKeysFile)
@@ -188,6 +212,16 @@ func (s *AuthorisedKeysKeysSuite) TestReplaceKeys(c *gc.C) {
 	c.Assert(actual, gc.DeepEquals, []string{replaceKey})
 }
 
+func (s *AuthorisedKeysKeysSuite) TestReplaceKeysNewline(c *gc.C) {
+	firstKey := sshtesting.ValidKeyOne.Key + " user@host"
+	writeAuthKeysFile(c, []string{firstKey}, authKeysFile)
+	err := ssh.ReplaceKeys(testSSHUser, sshtesting.ValidKeyTwo.Key+"\n"+sshtesting.ValidKeyThree.Key)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	actual, err := ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(actual, gc.DeepEquals, []string{firstKey})
+}
+
 func (s *AuthorisedKeysKeysSuite) TestReplaceKeepsUnrecognised(c *gc.C) {
 	writeAuthKeysFile(c, []string{sshtesting.ValidKeyOne.Key, "invalid-key"}, authKeysFile)
 	anotherKey := sshtesting.ValidKeyTwo.Key + " anotheruser@host"
@@ -346,3 +380,88 @@ func (s *AuthorisedKeysKeysSuite) TestDeleteSomeKeysFromFile(c *gc.C) {
 	c.Assert(keys, gc.HasLen, 2)
 	c.Assert(keys, jc.SameContents, []string{key1, key3})
 }
+
+func (s *AuthorisedKeysKeysSuite) TestConcurrentAddKeys(c *gc.C) {
+	const n = 10
+	keys := make([]string, n)
+	for i := range keys {
+		_, public, err := ssh.GenerateKey(fmt.Sprintf("user%d@host", i))
+		c.Assert(err, jc.ErrorIsNil)
+		keys[i] = strings.TrimSpace(public)
+	}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			c.Check(ssh.AddKeys(testSSHUser, key), jc.ErrorIsNil)
+		}(key)
+	}
+	wg.Wait()
+
+	actual, err := ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(actual, jc.SameContents, keys)
+}
+
+func (s *AuthorisedKeysKeysSuite) TestAddKeysWaitsForLock(c *gc.C) {
+	path, err := ssh.AuthorisedKeysPath(testSSHUser, authKeysFile)
+	c.Assert(err, jc.ErrorIsNil)
+	releaser, err := ssh.AcquireAuthorisedKeysLock(path)
+	c.Assert(err, jc.ErrorIsNil)
+
+	key := sshtesting.ValidKeyOne.Key + " user@host"
+	done := make(chan error, 1)
+	go func() {
+		done <- ssh.AddKeys(testSSHUser, key)
+	}()
+	select {
+	case err := <-done:
+		c.Fatalf("keys updated while lock held (err: %v)", err)
+	case <-time.After(gitjujutesting.ShortWait):
+	}
+
+	releaser.Release()
+	select {
+	case err := <-done:
+		c.Assert(err, jc.ErrorIsNil)
+	case <-time.After(gitjujutesting.LongWait):
+		c.Fatalf("timed out waiting for keys to be updated")
+	}
+	actual, err := ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(actual, gc.DeepEquals, []string{key})
+}
+
+func (s *AuthorisedKeysKeysSuite) TestAddKeysLockTimeout(c *gc.C) {
+	s.PatchValue(ssh.AuthorisedKeysLockTimeout, gitjujutesting.ShortWait)
+	path, err := ssh.AuthorisedKeysPath(testSSHUser, authKeysFile)
+	c.Assert(err, jc.ErrorIsNil)
+	releaser, err := ssh.AcquireAuthorisedKeysLock(path)
+	c.Assert(err, jc.ErrorIsNil)
+	defer releaser.Release()
+
+	err = ssh.AddKeys(testSSHUser, sshtesting.ValidKeyOne.Key+" user@host")
+	c.Assert(err, gc.ErrorMatches, "another process held the lock for .*/authorized_keys for more than .*: timeout acquiring mutex")
+	c.Assert(errors.Is(err, mutex.ErrTimeout), jc.IsTrue)
+}
+
+func (s *AuthorisedKeysKeysSuite) TestAddKeysConcurrentUpdate(c *gc.C) {
+	firstKey := sshtesting.ValidKeyOne.Key + " user@host"
+	writeAuthKeysFile(c, []string{firstKey}, authKeysFile)
+
+	// Simulate a writer that doesn't honour the lock.
+	otherKey := sshtesting.ValidKeyTwo.Key + " other@host"
+	s.PatchValue(ssh.BeforeAuthorisedKeysWrite, func(path string) {
+		err := ioutil.WriteFile(path, []byte(firstKey+"\n"+otherKey+"\n"), 0644)
+		c.Assert(err, jc.ErrorIsNil)
+	})
+	err := ssh.AddKeys(testSSHUser, sshtesting.ValidKeyThree.Key+" another@host")
+	c.Assert(err, gc.ErrorMatches, "updating .*/authorized_keys: authorised keys file changed during update")
+	c.Assert(errors.Is(err, ssh.ErrConcurrentUpdate), jc.IsTrue)
+
+	// The other writer's changes are not lost.
+	actual, err := ssh.ListKeys(testSSHUser, ssh.FullKeys)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(actual, gc.DeepEquals, []string{firstKey, otherKey})
+}
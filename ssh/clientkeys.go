@@ -4,6 +4,7 @@
 package ssh
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,16 +13,25 @@ import (
 	"sync"
 
 	"github.com/juju/collections/set"
+	"github.com/juju/errors"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/juju/utils/v4"
 )
 
 const clientKeyName = "juju_id_ed25519"
 
+// clientKeyComment is the comment given to generated client keys.
+const clientKeyComment = "juju-client-key"
+
 // PublicKeySuffix is the file extension for public key files.
 const PublicKeySuffix = ".pub"
 
+// CertificateSuffix is the suffix added to a private key's
+// filename to name the file holding its OpenSSH certificate.
+const CertificateSuffix = "-cert.pub"
+
 var (
 	clientKeysMutex sync.Mutex
 
@@ -29,8 +39,42 @@ var (
 	// to ssh.Signers. The private keys are those loaded
 	// from the client key directory, passed to LoadClientKeys.
 	clientKeys map[string]ssh.Signer
+
+	// clientCerts is a cached map of private key filenames
+	// to the certificates found alongside them.
+	clientCerts map[string]*ssh.Certificate
+
+	// passphraseFunc is called to obtain the passphrase
+	// for encrypted private keys.
+	passphraseFunc PassphraseFunc = readPassphrase
 )
 
+// PassphraseFunc returns the passphrase for the
+// encrypted private key in the specified file.
+type PassphraseFunc func(keyfile string) ([]byte, error)
+
+// SetPassphraseFunc sets the function called by LoadClientKeys to
+// obtain the passphrase for encrypted private keys. By default the
+// user is prompted for the passphrase if stdin is a terminal. If f
+// is nil, encrypted private keys cannot be loaded.
+func SetPassphraseFunc(f PassphraseFunc) {
+	clientKeysMutex.Lock()
+	defer clientKeysMutex.Unlock()
+	passphraseFunc = f
+}
+
+// readPassphrase prompts for the passphrase of a private key
+// on the terminal.
+var readPassphrase = func(keyfile string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("not running in a terminal, cannot prompt for passphrase")
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", keyfile)
+	defer fmt.Fprintln(os.Stderr)
+	return terminal.ReadPassword(fd)
+}
+
 // LoadClientKeys loads the client SSH keys from the
 // specified directory, and caches them as a process-wide
 // global. If the directory does not exist, it is created;
@@ -39,11 +83,26 @@ var (
 //
 // If the directory exists, then all pairs of files where one
 // has the same name as the other + ".pub" will be loaded as
-// private/public key pairs.
+// private/public key pairs. If there is also a file with the
+// private key's name + "-cert.pub", it will be loaded as an
+// OpenSSH certificate for the key pair.
+//
+// Encrypted private keys are decrypted with the passphrase
+// returned by the function set with SetPassphraseFunc.
 //
 // Calls to LoadClientKeys will clear the previously loaded
 // keys, and recompute the keys.
 func LoadClientKeys(dir string) error {
+	return LoadClientKeysWithParams(dir, KeyParams{})
+}
+
+// LoadClientKeysWithParams is like LoadClientKeys, but any key
+// pair that is created is generated according to params. If
+// params.Comment is empty, "juju-client-key" is used.
+func LoadClientKeysWithParams(dir string, params KeyParams) error {
+	if err := params.Validate(); err != nil {
+		return errors.Trace(err)
+	}
 	clientKeysMutex.Lock()
 	defer clientKeysMutex.Unlock()
 	dir, err := utils.NormalizePath(dir)
@@ -51,11 +110,12 @@ func LoadClientKeys(dir string) error {
 		return err
 	}
 	if _, err := os.Stat(dir); err == nil {
-		keys, err := loadClientKeys(dir)
+		keys, certs, err := loadClientKeys(dir)
 		if err != nil {
 			return err
 		} else if len(keys) > 0 {
 			clientKeys = keys
+			clientCerts = certs
 			return nil
 		}
 		// Directory exists but contains no keys;
@@ -64,12 +124,13 @@ func LoadClientKeys(dir string) error {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
-	keyfile, key, err := generateClientKey(dir)
+	keyfile, key, err := generateClientKey(dir, params)
 	if err != nil {
 		os.RemoveAll(dir)
 		return err
 	}
 	clientKeys = map[string]ssh.Signer{keyfile: key}
+	clientCerts = nil
 	return nil
 }
 
@@ -78,18 +139,22 @@ func ClearClientKeys() {
 	clientKeysMutex.Lock()
 	defer clientKeysMutex.Unlock()
 	clientKeys = nil
+	clientCerts = nil
 }
 
-func generateClientKey(dir string) (keyfile string, key ssh.Signer, err error) {
-	private, public, err := GenerateKey("juju-client-key")
-	if err != nil {
-		return "", nil, err
+func generateClientKey(dir string, params KeyParams) (keyfile string, key ssh.Signer, err error) {
+	if params.Comment == "" {
+		params.Comment = clientKeyComment
 	}
-	clientPrivateKey, err := ssh.ParsePrivateKey([]byte(private))
+	private, public, clientPrivateKey, err := generateKey(params)
 	if err != nil {
 		return "", nil, err
 	}
-	privkeyFilename := filepath.Join(dir, clientKeyName)
+	name := clientKeyName
+	if params.Type != "" {
+		name = "juju_id_" + string(params.Type)
+	}
+	privkeyFilename := filepath.Join(dir, name)
 	if err = ioutil.WriteFile(privkeyFilename, []byte(private), 0600); err != nil {
 		return "", nil, err
 	}
@@ -100,34 +165,106 @@ func generateClientKey(dir string) (keyfile string, key ssh.Signer, err error) {
 	return privkeyFilename, clientPrivateKey, nil
 }
 
-func loadClientKeys(dir string) (map[string]ssh.Signer, error) {
+func loadClientKeys(dir string) (map[string]ssh.Signer, map[string]*ssh.Certificate, error) {
 	publicKeyFiles, err := publicKeyFiles(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	keys := make(map[string]ssh.Signer, len(publicKeyFiles))
+	certs := make(map[string]*ssh.Certificate)
 	for _, filename := range publicKeyFiles {
 		filename = filename[:len(filename)-len(PublicKeySuffix)]
 		data, err := ioutil.ReadFile(filename)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		keys[filename], err = ssh.ParsePrivateKey(data)
+		keys[filename], err = parsePrivateKey(filename, data)
 		if err != nil {
-			return nil, fmt.Errorf("parsing key file %q: %v", filename, err)
+			return nil, nil, fmt.Errorf("parsing key file %q: %v", filename, err)
 		}
+		cert, err := readCertificate(filename + CertificateSuffix)
+		if os.IsNotExist(errors.Cause(err)) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		certs[filename] = cert
 	}
-	return keys, nil
+	return keys, certs, nil
+}
+
+// parsePrivateKey parses the private key read from the specified
+// file, obtaining a passphrase from passphraseFunc if it is encrypted.
+func parsePrivateKey(filename string, data []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); !ok || passphraseFunc == nil {
+		return signer, err
+	}
+	passphrase, err := passphraseFunc(filename)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading passphrase")
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+}
+
+// readCertificate reads an OpenSSH certificate from the
+// specified file, in the format written by ssh-keygen.
+func readCertificate(filename string) (*ssh.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, errors.Annotatef(err, "parsing certificate file %q", filename)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.Errorf("parsing certificate file %q: %s key is not a certificate", filename, key.Type())
+	}
+	return cert, nil
+}
+
+// certSigners returns a signer for each of the certificates
+// that certify the public key of one of the given signers,
+// followed by the signers themselves. Certificates that do
+// not match any signer are ignored.
+func certSigners(certs []*ssh.Certificate, signers []ssh.Signer) []ssh.Signer {
+	var result []ssh.Signer
+	for _, cert := range certs {
+		certKey := cert.Key.Marshal()
+		for _, signer := range signers {
+			if !bytes.Equal(certKey, signer.PublicKey().Marshal()) {
+				continue
+			}
+			certSigner, err := ssh.NewCertSigner(cert, signer)
+			if err != nil {
+				logger.Warningf("cannot use %s certificate: %v", cert.Type(), err)
+				break
+			}
+			result = append(result, certSigner)
+			break
+		}
+	}
+	return append(result, signers...)
 }
 
-// privateKeys returns the private keys loaded by LoadClientKeys.
+// privateKeys returns the private keys loaded by LoadClientKeys,
+// preceded by signers for any of their certificates.
 func privateKeys() (signers []ssh.Signer) {
 	clientKeysMutex.Lock()
 	defer clientKeysMutex.Unlock()
-	for _, key := range clientKeys {
+	var certs []*ssh.Certificate
+	for filename, key := range clientKeys {
 		signers = append(signers, key)
+		if cert, ok := clientCerts[filename]; ok {
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return signers
 	}
-	return signers
+	return certSigners(certs, signers)
 }
 
 // PrivateKeyFiles returns the filenames of private SSH keys loaded by
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/juju/errors"
 	gitjujutesting "github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -104,3 +105,55 @@ func (s *ClientKeysSuite) TestLoadClientKeysDirExists(c *gc.C) {
 	c.Assert(err, jc.ErrorIsNil)
 	checkPrivateKeyFiles(c, "~/.juju/ssh/juju_id_ed25519")
 }
+
+func (s *ClientKeysSuite) TestLoadClientKeysWithParams(c *gc.C) {
+	err := ssh.LoadClientKeysWithParams("~/.juju/ssh", ssh.KeyParams{Type: ssh.KeyTypeECDSA, Bits: 384})
+	c.Assert(err, jc.ErrorIsNil)
+	checkPrivateKeyFiles(c, "~/.juju/ssh/juju_id_ecdsa")
+	data, err := ioutil.ReadFile(gitjujutesting.HomePath(".juju", "ssh", "juju_id_ecdsa.pub"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), jc.HasPrefix, "ecdsa-sha2-nistp384 ")
+	c.Assert(string(data), jc.HasSuffix, " juju-client-key\n")
+
+	err = ssh.LoadClientKeysWithParams("~/.juju/ssh", ssh.KeyParams{Type: "dsa"})
+	c.Assert(err, gc.ErrorMatches, `key type "dsa" not valid`)
+}
+
+func (s *ClientKeysSuite) TestLoadClientKeysEncrypted(c *gc.C) {
+	s.PatchValue(ssh.ClientPassphraseFunc, ssh.PassphraseFunc(nil))
+	var prompted []string
+	ssh.SetPassphraseFunc(func(keyfile string) ([]byte, error) {
+		prompted = append(prompted, keyfile)
+		return []byte("sekrit"), nil
+	})
+	err := ssh.LoadClientKeysWithParams("~/.juju/ssh", ssh.KeyParams{Passphrase: []byte("sekrit")})
+	c.Assert(err, jc.ErrorIsNil)
+	checkPrivateKeyFiles(c, "~/.juju/ssh/juju_id_ed25519")
+	// The generated key is not read back, so no passphrase is needed.
+	c.Assert(prompted, gc.HasLen, 0)
+
+	// Loading the encrypted key requires the passphrase.
+	ssh.ClearClientKeys()
+	err = ssh.LoadClientKeys("~/.juju/ssh")
+	c.Assert(err, jc.ErrorIsNil)
+	checkPrivateKeyFiles(c, "~/.juju/ssh/juju_id_ed25519")
+	keyfile, err := utils.NormalizePath("~/.juju/ssh/juju_id_ed25519")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(prompted, jc.DeepEquals, []string{keyfile})
+
+	ssh.SetPassphraseFunc(func(string) ([]byte, error) {
+		return []byte("wrong"), nil
+	})
+	err = ssh.LoadClientKeys("~/.juju/ssh")
+	c.Assert(err, gc.ErrorMatches, `parsing key file ".*juju_id_ed25519": x509: decryption password incorrect`)
+
+	ssh.SetPassphraseFunc(func(string) ([]byte, error) {
+		return nil, errors.New("no tty")
+	})
+	err = ssh.LoadClientKeys("~/.juju/ssh")
+	c.Assert(err, gc.ErrorMatches, `parsing key file ".*juju_id_ed25519": reading passphrase: no tty`)
+
+	ssh.SetPassphraseFunc(nil)
+	err = ssh.LoadClientKeys("~/.juju/ssh")
+	c.Assert(err, gc.ErrorMatches, `parsing key file ".*juju_id_ed25519": ssh: this private key is passphrase protected`)
+}
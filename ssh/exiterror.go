@@ -0,0 +1,159 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/juju/utils/v4"
+)
+
+// opensshErrorStatus is the status OpenSSH exits with
+// when an error occurs, such as failing to connect.
+const opensshErrorStatus = 255
+
+// ExitError is returned by Cmd.Wait when a command does not complete
+// successfully, whichever Client implementation created it.
+// It distinguishes between a command exiting with a non-zero status,
+// a command terminated by a signal, and a failure of the connection
+// to the remote host.
+type ExitError struct {
+	status     int
+	signal     string
+	connection bool
+	err        error
+}
+
+// ExitStatus returns the exit status of the command,
+// or -1 if the command did not exit normally.
+//
+// OpenSSH exits with status 255 when an error occurs, so for
+// commands run by OpenSSHClient this status is also reported
+// as a connection error.
+func (e *ExitError) ExitStatus() int {
+	return e.status
+}
+
+// Signal returns the name of the signal, such as "KILL", that
+// terminated the command, or the empty string if the command
+// was not terminated by a signal.
+func (e *ExitError) Signal() string {
+	return e.signal
+}
+
+// IsConnectionError reports whether the command failed because
+// the connection to the remote host could not be established
+// or was lost.
+func (e *ExitError) IsConnectionError() bool {
+	return e.connection
+}
+
+// Error implements error.
+func (e *ExitError) Error() string {
+	switch {
+	case e.connection && e.err != nil:
+		return e.err.Error()
+	case e.signal != "":
+		return fmt.Sprintf("command terminated by signal %s", e.signal)
+	}
+	return fmt.Sprintf("exit status %d", e.status)
+}
+
+// Unwrap returns the error reported by the underlying
+// implementation, if any.
+func (e *ExitError) Unwrap() error {
+	return e.err
+}
+
+// IsExitError reports whether err is an *ExitError.
+func IsExitError(err error) bool {
+	_, ok := err.(*ExitError)
+	return ok
+}
+
+// rcPassthroughError returns a *utils.RcPassthroughError for an
+// *ExitError reporting a non-zero exit status from the command, as
+// Run returned before ExitError was introduced. Other errors,
+// including connection errors, are returned as is.
+func rcPassthroughError(err error) error {
+	if exitErr, ok := err.(*ExitError); ok && exitErr.status > 0 && !exitErr.connection {
+		return utils.NewRcPassthroughError(exitErr.status)
+	}
+	return err
+}
+
+// connectionError returns an *ExitError for a failure
+// to establish a connection or session.
+func connectionError(err error) error {
+	return &ExitError{status: -1, connection: true, err: err}
+}
+
+// opensshExitError returns an *ExitError for the error returned
+// from waiting for an OpenSSH process, or err if it did not come
+// from the process exiting.
+func opensshExitError(err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+	status, ok := exitErr.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok {
+		return err
+	}
+	switch {
+	case status.Exited():
+		return &ExitError{
+			status:     status.ExitStatus(),
+			connection: status.ExitStatus() == opensshErrorStatus,
+			err:        err,
+		}
+	case status.Signaled():
+		return &ExitError{status: -1, signal: signalName(status.Signal()), err: err}
+	}
+	return err
+}
+
+// goCryptoExitError returns an *ExitError for the
+// error returned from waiting for a go.crypto session.
+func goCryptoExitError(err error) error {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *ssh.ExitError:
+		if err.Signal() != "" {
+			return &ExitError{status: -1, signal: err.Signal(), err: err}
+		}
+		return &ExitError{status: err.ExitStatus(), err: err}
+	}
+	// The session ended without an exit status,
+	// or the connection failed while waiting.
+	return connectionError(err)
+}
+
+// signalNames maps signals to the names used
+// by the SSH protocol, as described in RFC 4254.
+var signalNames = map[syscall.Signal]ssh.Signal{
+	syscall.SIGABRT: ssh.SIGABRT,
+	syscall.SIGALRM: ssh.SIGALRM,
+	syscall.SIGFPE:  ssh.SIGFPE,
+	syscall.SIGHUP:  ssh.SIGHUP,
+	syscall.SIGILL:  ssh.SIGILL,
+	syscall.SIGINT:  ssh.SIGINT,
+	syscall.SIGKILL: ssh.SIGKILL,
+	syscall.SIGPIPE: ssh.SIGPIPE,
+	syscall.SIGQUIT: ssh.SIGQUIT,
+	syscall.SIGSEGV: ssh.SIGSEGV,
+	syscall.SIGTERM: ssh.SIGTERM,
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return string(name)
+	}
+	return sig.String()
+}
@@ -17,9 +17,23 @@ var (
 	InitDefaultClient   = initDefaultClient
 	DefaultIdentities   = &defaultIdentities
 	SSHDial             = &sshDial
+	SSHHandshake        = sshHandshake
+	KeepAlive           = keepAlive
 	ED25519GenerateKey  = &ed25519GenerateKey
+	RSAGenerateKey      = &rsaGenerateKey
 	TestCopyReader      = copyReader
 	TestNewCmd          = newCmd
+
+	AuthorisedKeysPath        = authorisedKeysPath
+	AcquireAuthorisedKeysLock = acquireAuthorisedKeysLock
+	AuthorisedKeysLockTimeout = &authorisedKeysLockTimeout
+	BeforeAuthorisedKeysWrite = &beforeAuthorisedKeysWrite
+	ClientPassphraseFunc      = &passphraseFunc
+
+	IsTerminal         = &isTerminal
+	GetTerminalSize    = &getTerminalSize
+	MakeTerminalRaw    = &makeTerminalRaw
+	NotifyWindowChange = &notifyWindowChange
 )
 
 type ReadLineWriter readLineWriter
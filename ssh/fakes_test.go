@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"sync"
 
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -123,3 +124,21 @@ func (ci *fakeCommandImpl) StderrPipe() (io.ReadCloser, io.Writer, error) {
 	ci.calls = append(ci.calls, "StderrPipe")
 	return ioutil.NopCloser(&ci.stderrData), ci.stderrRaw, ci.err
 }
+
+type auditRecorder struct {
+	mu       sync.Mutex
+	started  []ssh.AuditEvent
+	finished []ssh.AuditEvent
+}
+
+func (r *auditRecorder) CommandStarted(event ssh.AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = append(r.started, event)
+}
+
+func (r *auditRecorder) CommandFinished(event ssh.AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished = append(r.finished, event)
+}
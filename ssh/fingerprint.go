@@ -5,29 +5,150 @@ package ssh
 
 import (
 	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"strings"
 
 	"github.com/juju/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// FingerprintFormat identifies the format of a key fingerprint.
+type FingerprintFormat int
+
+const (
+	// FingerprintMD5 is the legacy format, of colon separated
+	// hex digits such as "86:ed:1b:...:0f:68".
+	FingerprintMD5 FingerprintFormat = iota
+
+	// FingerprintSHA256 is the format shown by OpenSSH
+	// by default, such as "SHA256:o4mn2Zj6...".
+	FingerprintSHA256
+)
+
+// Prefixes of fingerprints in the format shown by OpenSSH.
+const (
+	md5FingerprintPrefix    = "MD5:"
+	sha256FingerprintPrefix = "SHA256:"
 )
 
 // KeyFingerprint returns the fingerprint and comment for the specified key
 // in authorized_key format. Fingerprints are generated according to RFC4716.
 // See ttp://www.ietf.org/rfc/rfc4716.txt, section 4.
 func KeyFingerprint(key string) (fingerprint, comment string, err error) {
+	return KeyFingerprintWithFormat(key, FingerprintMD5)
+}
+
+// KeyFingerprintWithFormat returns the fingerprint, in the given
+// format, and the comment for the specified key in authorized_key
+// format.
+func KeyFingerprintWithFormat(key string, format FingerprintFormat) (fingerprint, comment string, err error) {
 	ak, err := ParseAuthorisedKey(key)
 	if err != nil {
 		return "", "", errors.Errorf("generating key fingerprint: %v", err)
 	}
-	hash := md5.New()
-	hash.Write(ak.Key)
-	sum := hash.Sum(nil)
+	switch format {
+	case FingerprintMD5:
+		return md5Fingerprint(ak.Key), ak.Comment, nil
+	case FingerprintSHA256:
+		return sha256Fingerprint(ak.Key), ak.Comment, nil
+	}
+	return "", "", errors.NotValidf("fingerprint format %d", format)
+}
+
+func md5Fingerprint(key []byte) string {
+	sum := md5.Sum(key)
 	var buf bytes.Buffer
-	for i := 0; i < hash.Size(); i++ {
+	for i, b := range sum {
 		if i > 0 {
 			buf.WriteByte(':')
 		}
-		buf.WriteString(fmt.Sprintf("%02x", sum[i]))
+		buf.WriteString(fmt.Sprintf("%02x", b))
+	}
+	return buf.String()
+}
+
+func sha256Fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return sha256FingerprintPrefix + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// KeyInfo describes a public key.
+type KeyInfo struct {
+	// Type is the key's algorithm, such as "ssh-ed25519".
+	Type string
+
+	// Bits is the size of the key, or zero if it is unknown.
+	Bits int
+
+	// MD5Fingerprint and SHA256Fingerprint hold the
+	// key's fingerprint in each format.
+	MD5Fingerprint    string
+	SHA256Fingerprint string
+
+	// Comment is the key's comment, if any.
+	Comment string
+}
+
+// ParseKeyInfo returns information about the specified
+// key in authorized_key format.
+func ParseKeyInfo(key string) (KeyInfo, error) {
+	ak, err := ParseAuthorisedKey(key)
+	if err != nil {
+		return KeyInfo{}, errors.Trace(err)
+	}
+	info := KeyInfo{
+		Type:              ak.Type,
+		MD5Fingerprint:    md5Fingerprint(ak.Key),
+		SHA256Fingerprint: sha256Fingerprint(ak.Key),
+		Comment:           ak.Comment,
+	}
+	if pub, err := ssh.ParsePublicKey(ak.Key); err == nil {
+		info.Bits = keyBits(pub)
+	}
+	return info, nil
+}
+
+// keyBits returns the size of the public key in bits,
+// or zero if it cannot be determined.
+func keyBits(pub ssh.PublicKey) int {
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		pub = cert.Key
+	}
+	cpub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch key := cpub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case *dsa.PublicKey:
+		return key.P.BitLen()
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// normaliseFingerprint returns the fingerprint in the form returned
+// by KeyFingerprintWithFormat, and whether it was recognised as a
+// fingerprint. Fingerprints may be in either format, and MD5
+// fingerprints may be prefixed with "MD5:" as shown by OpenSSH.
+func normaliseFingerprint(fingerprint string) (string, bool) {
+	if strings.HasPrefix(fingerprint, sha256FingerprintPrefix) {
+		return fingerprint, true
+	}
+	fingerprint = strings.TrimPrefix(fingerprint, md5FingerprintPrefix)
+	if len(fingerprint) != 3*md5.Size-1 {
+		return "", false
 	}
-	return buf.String(), ak.Comment, nil
+	return strings.ToLower(fingerprint), true
 }
@@ -35,3 +35,90 @@ func (s *FingerprintSuite) TestKeyFingerprintError(c *gc.C) {
 	_, _, err := ssh.KeyFingerprint("invalid key")
 	c.Assert(err, gc.ErrorMatches, `generating key fingerprint: invalid authorized_key "invalid key"`)
 }
+
+// Keys generated with ssh-keygen, whose fingerprints and
+// sizes are as reported by "ssh-keygen -l".
+const (
+	ed25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIP569XGbS0rclqwEg9JXEvFeFqokrTuyHmfjVmKMekq4 ed@host"
+	ecdsaKey   = "ecdsa-sha2-nistp384 AAAAE2VjZHNhLXNoYTItbmlzdHAzODQAAAAIbmlzdHAzODQAAABhBFqj3OKq4gQvlKxrvjaY0luds8+" +
+		"jFdc1wis+uoxOEqEVP8E5YhyaR6tT1rc9IQkzQD08cNsoWTQ7ssZ3DZDQA2oAXle9PQapxjMIjVzfXWOZjUdlKqPCMErfMnZAsoLGHg== ec@host"
+)
+
+func (s *FingerprintSuite) TestKeyFingerprintWithFormat(c *gc.C) {
+	for i, test := range []struct {
+		key    string
+		md5    string
+		sha256 string
+	}{{
+		key:    sshtesting.ValidKeyOne.Key,
+		md5:    sshtesting.ValidKeyOne.Fingerprint,
+		sha256: "SHA256:o4mn2Zj6qXG0gDlan4PqSrz9/5bt3OQvsUZADv4KXu0",
+	}, {
+		key:    sshtesting.ValidKeyTwo.Key,
+		md5:    sshtesting.ValidKeyTwo.Fingerprint,
+		sha256: "SHA256:/gOSUCn3qYtAJbM8GnxhyjVSKLKjT7b+ItzQRjgEXtM",
+	}, {
+		key:    sshtesting.ValidKeyThree.Key,
+		md5:    sshtesting.ValidKeyThree.Fingerprint,
+		sha256: "SHA256:eSvjPib3cU9Gx7d9iF6631jQVEn9tF+a7IkthGHzvJ0",
+	}, {
+		key:    ed25519Key,
+		md5:    "48:24:74:39:34:4f:4b:39:0f:f9:9c:7c:f9:ff:f0:97",
+		sha256: "SHA256:isBhdgcPLIOoZs7frGhHSS+OtrjhifF5syCjdJrPHTI",
+	}} {
+		c.Logf("test %d", i)
+		fingerprint, _, err := ssh.KeyFingerprintWithFormat(test.key, ssh.FingerprintMD5)
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(fingerprint, gc.Equals, test.md5)
+		fingerprint, _, err = ssh.KeyFingerprintWithFormat(test.key, ssh.FingerprintSHA256)
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(fingerprint, gc.Equals, test.sha256)
+	}
+}
+
+func (s *FingerprintSuite) TestKeyFingerprintWithFormatInvalid(c *gc.C) {
+	_, _, err := ssh.KeyFingerprintWithFormat(sshtesting.ValidKeyOne.Key, ssh.FingerprintFormat(99))
+	c.Assert(err, gc.ErrorMatches, "fingerprint format 99 not valid")
+}
+
+func (s *FingerprintSuite) TestParseKeyInfo(c *gc.C) {
+	for i, test := range []struct {
+		key  string
+		info ssh.KeyInfo
+	}{{
+		key: sshtesting.ValidKeyOne.Key + " user@host",
+		info: ssh.KeyInfo{
+			Type:              "ssh-rsa",
+			Bits:              2048,
+			MD5Fingerprint:    sshtesting.ValidKeyOne.Fingerprint,
+			SHA256Fingerprint: "SHA256:o4mn2Zj6qXG0gDlan4PqSrz9/5bt3OQvsUZADv4KXu0",
+			Comment:           "user@host",
+		},
+	}, {
+		key: ed25519Key,
+		info: ssh.KeyInfo{
+			Type:              "ssh-ed25519",
+			Bits:              256,
+			MD5Fingerprint:    "48:24:74:39:34:4f:4b:39:0f:f9:9c:7c:f9:ff:f0:97",
+			SHA256Fingerprint: "SHA256:isBhdgcPLIOoZs7frGhHSS+OtrjhifF5syCjdJrPHTI",
+			Comment:           "ed@host",
+		},
+	}, {
+		key: `no-pty ` + ecdsaKey,
+		info: ssh.KeyInfo{
+			Type:              "ecdsa-sha2-nistp384",
+			Bits:              384,
+			MD5Fingerprint:    "8b:c2:e8:00:94:7d:cf:25:d4:24:74:d3:cc:4f:e3:4e",
+			SHA256Fingerprint: "SHA256:HPcNylCgPfuNtIsVi7zMNKglU9zRb+Xji62nkrxro/I",
+			Comment:           "ec@host",
+		},
+	}} {
+		c.Logf("test %d", i)
+		info, err := ssh.ParseKeyInfo(test.key)
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(info, jc.DeepEquals, test.info)
+	}
+
+	_, err := ssh.ParseKeyInfo("invalid key")
+	c.Assert(err, gc.ErrorMatches, `invalid authorized_key "invalid key"`)
+}
@@ -4,8 +4,12 @@
 package ssh
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"encoding/pem"
 	"fmt"
 	"strings"
@@ -17,28 +21,131 @@ import (
 // ed25519GenerateKey allows for tests to patch out ed25519 key generation
 var ed25519GenerateKey = ed25519.GenerateKey
 
+// rsaGenerateKey allows for tests to patch out rsa key generation
+var rsaGenerateKey = rsa.GenerateKey
+
+// KeyType identifies the algorithm of a generated SSH key.
+type KeyType string
+
+const (
+	KeyTypeED25519 KeyType = "ed25519"
+	KeyTypeECDSA   KeyType = "ecdsa"
+	KeyTypeRSA     KeyType = "rsa"
+)
+
+// Default and minimum key sizes, in bits.
+const (
+	defaultECDSABits = 256
+	defaultRSABits   = 3072
+	minRSABits       = 2048
+)
+
+// KeyParams holds the parameters used to generate a key
+// with GenerateKeyWithParams.
+type KeyParams struct {
+	// Type is the type of key to generate.
+	// If empty, an Ed25519 key is generated.
+	Type KeyType
+
+	// Bits is the size of the key. ECDSA keys may be 256, 384
+	// or 521 bits, defaulting to 256. RSA keys must be at least
+	// 2048 bits, defaulting to 3072. Bits is ignored for Ed25519
+	// keys, which have a fixed size.
+	Bits int
+
+	// Comment is added to the private key and
+	// as the comment part of the public key.
+	Comment string
+
+	// Passphrase, if not empty, is used to encrypt the private key.
+	Passphrase []byte
+}
+
+// Validate returns an error if the parameters are not valid.
+func (p KeyParams) Validate() error {
+	switch p.Type {
+	case "", KeyTypeED25519:
+	case KeyTypeECDSA:
+		if _, err := ecdsaCurve(p.Bits); err != nil {
+			return errors.Trace(err)
+		}
+	case KeyTypeRSA:
+		if p.Bits != 0 && p.Bits < minRSABits {
+			return errors.NotValidf("RSA key size %d bits, minimum is %d", p.Bits, minRSABits)
+		}
+	default:
+		return errors.NotValidf("key type %q", p.Type)
+	}
+	return nil
+}
+
+func ecdsaCurve(bits int) (elliptic.Curve, error) {
+	switch bits {
+	case 0, 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	}
+	return nil, errors.NotValidf("ECDSA key size %d bits", bits)
+}
+
 // GenerateKey makes a ED25519 no-passphrase SSH capable key.
 // The private key returned is encoded to ASCII using the PKCS1 encoding.
 // The public key is suitable to be added into an authorized_keys file,
 // and has the comment passed in as the comment part of the key.
 func GenerateKey(comment string) (private, public string, err error) {
-	_, privateKey, err := ed25519GenerateKey(rand.Reader)
+	return GenerateKeyWithParams(KeyParams{Comment: comment})
+}
+
+// GenerateKeyWithParams makes an SSH key of the type and size given in
+// params. The private key returned is encoded in the OpenSSH format, and
+// is encrypted if params.Passphrase is not empty. The public key is
+// suitable to be added into an authorized_keys file.
+func GenerateKeyWithParams(params KeyParams) (private, public string, err error) {
+	private, public, _, err = generateKey(params)
+	return private, public, err
+}
+
+func generateKey(params KeyParams) (private, public string, signer ssh.Signer, err error) {
+	if err := params.Validate(); err != nil {
+		return "", "", nil, errors.Trace(err)
+	}
+	var privateKey crypto.Signer
+	switch params.Type {
+	case "", KeyTypeED25519:
+		_, privateKey, err = ed25519GenerateKey(rand.Reader)
+	case KeyTypeECDSA:
+		curve, _ := ecdsaCurve(params.Bits)
+		privateKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeRSA:
+		bits := params.Bits
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		privateKey, err = rsaGenerateKey(rand.Reader, bits)
+	}
 	if err != nil {
-		return "", "", errors.Trace(err)
+		return "", "", nil, errors.Trace(err)
 	}
 
-	pemBlock, err := ssh.MarshalPrivateKey(privateKey, comment)
+	var pemBlock *pem.Block
+	if len(params.Passphrase) > 0 {
+		pemBlock, err = ssh.MarshalPrivateKeyWithPassphrase(privateKey, params.Comment, params.Passphrase)
+	} else {
+		pemBlock, err = ssh.MarshalPrivateKey(privateKey, params.Comment)
+	}
 	if err != nil {
-		return "", "", errors.Trace(err)
+		return "", "", nil, errors.Trace(err)
 	}
 	identity := pem.EncodeToMemory(pemBlock)
 
-	public, err = PublicKey(identity, comment)
+	signer, err = ssh.NewSignerFromSigner(privateKey)
 	if err != nil {
-		return "", "", errors.Trace(err)
+		return "", "", nil, errors.Trace(err)
 	}
-
-	return string(identity), public, nil
+	return string(identity), authorisedKeyLine(signer.PublicKey(), params.Comment), signer, nil
 }
 
 // PublicKey returns the public key for any private key. The public key is
@@ -49,11 +156,12 @@ func PublicKey(privateKey []byte, comment string) (string, error) {
 	if err != nil {
 		return "", errors.Annotate(err, "failed to load key")
 	}
+	return authorisedKeyLine(signer.PublicKey(), comment), nil
+}
 
-	auth_key := string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+func authorisedKeyLine(key ssh.PublicKey, comment string) string {
+	auth_key := string(ssh.MarshalAuthorizedKey(key))
 	// Strip off the trailing new line so we can add a comment.
 	auth_key = strings.TrimSpace(auth_key)
-	public := fmt.Sprintf("%s %s\n", auth_key, comment)
-
-	return public, nil
+	return fmt.Sprintf("%s %s\n", auth_key, comment)
 }
@@ -0,0 +1,401 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/mutex/v2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/juju/utils/v4"
+)
+
+// Markers that may precede the host patterns of a known_hosts entry.
+const (
+	MarkerCertAuthority = "@cert-authority"
+	MarkerRevoked       = "@revoked"
+)
+
+// knownHostsMutexName is the name of the mutex held
+// while a known_hosts file is being rewritten.
+const knownHostsMutexName = "juju-ssh-client"
+
+// hashedHostPrefix is the prefix of a hashed host name,
+// which is followed by the base64 encoded salt and hash.
+const hashedHostPrefix = "|1|"
+
+// KnownHost is an entry in a known_hosts file.
+type KnownHost struct {
+	// Marker is MarkerCertAuthority, MarkerRevoked
+	// or empty for a plain host key.
+	Marker string
+
+	// Hosts holds the host patterns the entry applies to.
+	// Hashed host names are kept in their "|1|salt|hash" form.
+	Hosts []string
+
+	// Key is the host key, or the key of the certificate authority.
+	Key ssh.PublicKey
+
+	// Comment is the comment following the key, if any.
+	Comment string
+}
+
+// Matches reports whether the entry applies to the given address,
+// which may be in "host" or "host:port" form.
+func (h KnownHost) Matches(address string) bool {
+	return matchKnownHosts(h.Hosts, knownhosts.Normalize(address))
+}
+
+// String returns the entry as it appears in a known_hosts file.
+func (h KnownHost) String() string {
+	var parts []string
+	if h.Marker != "" {
+		parts = append(parts, h.Marker)
+	}
+	parts = append(parts,
+		strings.Join(h.Hosts, ","),
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(h.Key))),
+	)
+	if h.Comment != "" {
+		parts = append(parts, h.Comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+// knownHostsLine is a line of a known_hosts file. Lines that are
+// not entries, such as comments, are kept verbatim in text.
+type knownHostsLine struct {
+	text  string
+	entry *KnownHost
+}
+
+// KnownHosts holds the contents of a known_hosts file, as used by
+// both OpenSSH and the go.crypto client. Comments and lines that
+// cannot be parsed are preserved when the file is rewritten.
+type KnownHosts struct {
+	path  string
+	lines []knownHostsLine
+}
+
+// ReadKnownHosts reads the known_hosts file at the given path.
+// A file that does not exist is treated as an empty file.
+func ReadKnownHosts(path string) (*KnownHosts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+	k := &KnownHosts{path: path}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		line := knownHostsLine{text: text}
+		if trimmed := strings.TrimSpace(text); trimmed != "" && trimmed[0] != '#' {
+			marker, hosts, key, comment, _, err := ssh.ParseKnownHosts([]byte(trimmed))
+			if err != nil {
+				logger.Debugf("ignoring invalid entry in %s:%d: %v", path, n, err)
+			} else {
+				if marker != "" {
+					marker = "@" + marker
+				}
+				line.entry = &KnownHost{
+					Marker:  marker,
+					Hosts:   hosts,
+					Key:     key,
+					Comment: comment,
+				}
+			}
+		}
+		k.lines = append(k.lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Annotatef(err, "reading %s", path)
+	}
+	return k, nil
+}
+
+// Path returns the path of the known_hosts file.
+func (k *KnownHosts) Path() string {
+	return k.path
+}
+
+// Entries returns all of the entries in the file, in order.
+func (k *KnownHosts) Entries() []KnownHost {
+	var entries []KnownHost
+	for _, line := range k.lines {
+		if line.entry != nil {
+			entries = append(entries, *line.entry)
+		}
+	}
+	return entries
+}
+
+// Lookup returns the host key entries that apply to the given
+// address, which may be in "host" or "host:port" form. Keys that
+// have been revoked are not returned.
+func (k *KnownHosts) Lookup(address string) []KnownHost {
+	var entries []KnownHost
+	for _, entry := range k.Entries() {
+		if entry.Marker == "" && entry.Matches(address) && !k.IsRevoked(entry.Key) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// IsRevoked reports whether the given key has been revoked.
+func (k *KnownHosts) IsRevoked(key ssh.PublicKey) bool {
+	for _, entry := range k.Entries() {
+		if entry.Marker == MarkerRevoked && keysEqual(entry.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add records the given key for the address, which may be in "host"
+// or "host:port" form. Nothing is added if the key is already known
+// for the address.
+func (k *KnownHosts) Add(address string, key ssh.PublicKey) {
+	k.add(knownhosts.Normalize(address), key)
+}
+
+// AddHashed is like Add, but records the address hashed,
+// as OpenSSH does with "HashKnownHosts yes".
+func (k *KnownHosts) AddHashed(address string, key ssh.PublicKey) {
+	k.add(knownhosts.HashHostname(knownhosts.Normalize(address)), key)
+}
+
+func (k *KnownHosts) add(host string, key ssh.PublicKey) {
+	for _, entry := range k.Lookup(host) {
+		if keysEqual(entry.Key, key) {
+			return
+		}
+	}
+	k.lines = append(k.lines, knownHostsLine{
+		entry: &KnownHost{Hosts: []string{host}, Key: key},
+	})
+}
+
+// Remove removes all host key entries that apply to the given
+// address, and returns the number of entries removed. As with
+// "ssh-keygen -R", an entry listing several hosts is removed
+// entirely.
+func (k *KnownHosts) Remove(address string) int {
+	return k.removeIf(func(entry *KnownHost) bool {
+		return entry.Marker == "" && entry.Matches(address)
+	})
+}
+
+// Revoke marks the given key as revoked for all hosts,
+// and removes any host key entries using it.
+func (k *KnownHosts) Revoke(key ssh.PublicKey) {
+	k.removeIf(func(entry *KnownHost) bool {
+		return entry.Marker == "" && keysEqual(entry.Key, key)
+	})
+	if k.IsRevoked(key) {
+		return
+	}
+	k.lines = append(k.lines, knownHostsLine{
+		entry: &KnownHost{Marker: MarkerRevoked, Hosts: []string{"*"}, Key: key},
+	})
+}
+
+func (k *KnownHosts) removeIf(remove func(*KnownHost) bool) int {
+	var removed int
+	lines := k.lines[:0]
+	for _, line := range k.lines {
+		if line.entry != nil && remove(line.entry) {
+			removed++
+			continue
+		}
+		lines = append(lines, line)
+	}
+	k.lines = lines
+	return removed
+}
+
+// Hash replaces the plain host names of host key entries with their
+// hashes, as "ssh-keygen -H" does. Entries listing several hosts are
+// split into one entry per host. Entries containing wildcards or
+// negated patterns are left as they are.
+func (k *KnownHosts) Hash() {
+	var lines []knownHostsLine
+	for _, line := range k.lines {
+		entry := line.entry
+		if entry == nil || entry.Marker != "" || !hashableHosts(entry.Hosts) {
+			lines = append(lines, line)
+			continue
+		}
+		for _, host := range entry.Hosts {
+			if !strings.HasPrefix(host, hashedHostPrefix) {
+				host = knownhosts.HashHostname(host)
+			}
+			lines = append(lines, knownHostsLine{entry: &KnownHost{
+				Hosts:   []string{host},
+				Key:     entry.Key,
+				Comment: entry.Comment,
+			}})
+		}
+	}
+	k.lines = lines
+}
+
+// Bytes returns the contents of the known_hosts file.
+func (k *KnownHosts) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, line := range k.lines {
+		if line.text != "" || line.entry == nil {
+			buf.WriteString(line.text)
+		} else {
+			buf.WriteString(line.entry.String())
+		}
+		buf.WriteRune('\n')
+	}
+	return buf.Bytes()
+}
+
+// Write atomically rewrites the known_hosts file. Changes made to
+// the file since it was read are lost; use UpdateKnownHosts to
+// modify a file that may be updated concurrently.
+func (k *KnownHosts) Write() error {
+	releaser, err := acquireKnownHostsLock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer releaser.Release()
+	return errors.Trace(k.write())
+}
+
+func (k *KnownHosts) write() error {
+	return utils.AtomicWriteFile(k.path, k.Bytes(), 0600)
+}
+
+// UpdateKnownHosts reads the known_hosts file at the given path,
+// calls update to modify it and then atomically rewrites it. No
+// other process using this package can modify the file until the
+// update is complete.
+func UpdateKnownHosts(path string, update func(*KnownHosts) error) error {
+	releaser, err := acquireKnownHostsLock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer releaser.Release()
+
+	k, err := ReadKnownHosts(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := update(k); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(k.write())
+}
+
+func acquireKnownHostsLock() (mutex.Releaser, error) {
+	return mutex.Acquire(mutex.Spec{
+		Name:  knownHostsMutexName,
+		Clock: clock.WallClock,
+		Delay: time.Second,
+	})
+}
+
+// hashableHosts reports whether all of the given
+// hosts are names rather than patterns.
+func hashableHosts(hosts []string) bool {
+	for _, host := range hosts {
+		if strings.ContainsAny(host, "*?!") {
+			return false
+		}
+	}
+	return true
+}
+
+// matchKnownHosts reports whether the normalized address matches the
+// host patterns of a known_hosts entry. As with OpenSSH, a matching
+// negated pattern prevents the entry from applying.
+func matchKnownHosts(patterns []string, address string) bool {
+	address = strings.ToLower(address)
+	var matched bool
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, hashedHostPrefix) {
+			if matchHashedHost(pattern, address) {
+				matched = true
+			}
+			continue
+		}
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.ToLower(strings.TrimPrefix(pattern, "!"))
+		if !wildcardMatch(pattern, address) {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// matchHashedHost reports whether the address matches
+// a host name hashed in the "|1|salt|hash" form.
+func matchHashedHost(hashed, address string) bool {
+	parts := strings.Split(strings.TrimPrefix(hashed, hashedHostPrefix), "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(address))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// wildcardMatch reports whether s matches the pattern,
+// in which '*' matches any sequence of characters and
+// '?' matches any single character.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func keysEqual(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
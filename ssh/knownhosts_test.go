@@ -0,0 +1,200 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/v4/ssh"
+	sshtesting "github.com/juju/utils/v4/ssh/testing"
+)
+
+type KnownHostsSuite struct {
+	testing.IsolationSuite
+	path string
+}
+
+var _ = gc.Suite(&KnownHostsSuite{})
+
+func (s *KnownHostsSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.path = filepath.Join(c.MkDir(), "known_hosts")
+}
+
+func parsePublicKey(c *gc.C, key string) cryptossh.PublicKey {
+	pub, _, _, _, err := cryptossh.ParseAuthorizedKey([]byte(key))
+	c.Assert(err, jc.ErrorIsNil)
+	return pub
+}
+
+func (s *KnownHostsSuite) writeFile(c *gc.C, lines ...string) {
+	err := os.WriteFile(s.path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *KnownHostsSuite) TestReadMissingFile(c *gc.C) {
+	k, err := ssh.ReadKnownHosts(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(k.Entries(), gc.HasLen, 0)
+	c.Assert(k.Lookup("example.com"), gc.HasLen, 0)
+}
+
+func (s *KnownHostsSuite) TestLookup(c *gc.C) {
+	keyOne := parsePublicKey(c, sshtesting.ValidKeyOne.Key)
+	keyTwo := parsePublicKey(c, sshtesting.ValidKeyTwo.Key)
+	keyThree := parsePublicKey(c, sshtesting.ValidKeyThree.Key)
+	s.writeFile(c,
+		"# a comment",
+		"example.com,10.0.0.1 "+sshtesting.ValidKeyOne.Key,
+		"[example.com]:2222 "+sshtesting.ValidKeyTwo.Key+" port-key",
+		knownhosts.HashHostname("hashed.example.com")+" "+sshtesting.ValidKeyThree.Key,
+		"*.internal,!bad.internal "+sshtesting.ValidKeyOne.Key,
+		"@cert-authority *.example.com "+sshtesting.ValidKeyTwo.Key,
+		"not a valid entry",
+	)
+	k, err := ssh.ReadKnownHosts(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(k.Entries(), gc.HasLen, 5)
+
+	for i, test := range []struct {
+		address string
+		keys    []cryptossh.PublicKey
+	}{
+		{"example.com", []cryptossh.PublicKey{keyOne}},
+		{"example.com:22", []cryptossh.PublicKey{keyOne}},
+		{"EXAMPLE.com", []cryptossh.PublicKey{keyOne}},
+		{"10.0.0.1:22", []cryptossh.PublicKey{keyOne}},
+		{"example.com:2222", []cryptossh.PublicKey{keyTwo}},
+		{"hashed.example.com", []cryptossh.PublicKey{keyThree}},
+		{"hashed.example.com:2222", nil},
+		{"db.internal", []cryptossh.PublicKey{keyOne}},
+		{"bad.internal", nil},
+		{"www.example.com", nil},
+	} {
+		c.Logf("test %d: %s", i, test.address)
+		var keys []cryptossh.PublicKey
+		for _, entry := range k.Lookup(test.address) {
+			keys = append(keys, entry.Key)
+		}
+		c.Check(keys, jc.DeepEquals, test.keys)
+	}
+}
+
+func (s *KnownHostsSuite) TestAddAndWrite(c *gc.C) {
+	s.writeFile(c, "# keep me", "example.com "+sshtesting.ValidKeyOne.Key)
+	k, err := ssh.ReadKnownHosts(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+
+	keyOne := parsePublicKey(c, sshtesting.ValidKeyOne.Key)
+	keyTwo := parsePublicKey(c, sshtesting.ValidKeyTwo.Key)
+	k.Add("example.com:22", keyOne)
+	k.Add("example.com:2222", keyTwo)
+	k.AddHashed("secret.example.com", keyTwo)
+	c.Assert(k.Write(), jc.ErrorIsNil)
+
+	data, err := os.ReadFile(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	c.Assert(lines, gc.HasLen, 4)
+	c.Assert(lines[:3], jc.DeepEquals, []string{
+		"# keep me",
+		"example.com " + sshtesting.ValidKeyOne.Key,
+		"[example.com]:2222 " + sshtesting.ValidKeyTwo.Key,
+	})
+	c.Assert(lines[3], jc.HasPrefix, "|1|")
+	c.Assert(lines[3], jc.HasSuffix, " "+sshtesting.ValidKeyTwo.Key)
+
+	// The file can be read by the go.crypto knownhosts package.
+	callback, err := knownhosts.New(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	err = callback("secret.example.com:22", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 22}, keyTwo)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *KnownHostsSuite) TestRemove(c *gc.C) {
+	s.writeFile(c,
+		"example.com,10.0.0.1 "+sshtesting.ValidKeyOne.Key,
+		knownhosts.HashHostname("example.com")+" "+sshtesting.ValidKeyTwo.Key,
+		"other.com "+sshtesting.ValidKeyThree.Key,
+		"@cert-authority example.com "+sshtesting.ValidKeyFour.Key,
+	)
+	k, err := ssh.ReadKnownHosts(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(k.Remove("example.com"), gc.Equals, 2)
+	c.Assert(k.Remove("example.com"), gc.Equals, 0)
+	c.Assert(string(k.Bytes()), gc.Equals, strings.Join([]string{
+		"other.com " + sshtesting.ValidKeyThree.Key,
+		"@cert-authority example.com " + sshtesting.ValidKeyFour.Key,
+	}, "\n")+"\n")
+}
+
+func (s *KnownHostsSuite) TestRevoke(c *gc.C) {
+	s.writeFile(c,
+		"example.com "+sshtesting.ValidKeyOne.Key,
+		"other.com "+sshtesting.ValidKeyTwo.Key,
+	)
+	k, err := ssh.ReadKnownHosts(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	keyOne := parsePublicKey(c, sshtesting.ValidKeyOne.Key)
+	k.Revoke(keyOne)
+	k.Revoke(keyOne)
+	c.Assert(k.IsRevoked(keyOne), jc.IsTrue)
+	c.Assert(k.Lookup("example.com"), gc.HasLen, 0)
+
+	// A revoked key cannot be looked up, even if added again.
+	k.Add("example.com", keyOne)
+	c.Assert(k.Lookup("example.com"), gc.HasLen, 0)
+	k.Remove("example.com")
+
+	c.Assert(string(k.Bytes()), gc.Equals, strings.Join([]string{
+		"other.com " + sshtesting.ValidKeyTwo.Key,
+		"@revoked * " + sshtesting.ValidKeyOne.Key,
+	}, "\n")+"\n")
+}
+
+func (s *KnownHostsSuite) TestHash(c *gc.C) {
+	s.writeFile(c,
+		"example.com,[example.com]:2222 "+sshtesting.ValidKeyOne.Key+" comment",
+		"*.internal "+sshtesting.ValidKeyTwo.Key,
+	)
+	k, err := ssh.ReadKnownHosts(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	k.Hash()
+
+	entries := k.Entries()
+	c.Assert(entries, gc.HasLen, 3)
+	for _, entry := range entries[:2] {
+		c.Check(entry.Hosts, gc.HasLen, 1)
+		c.Check(entry.Hosts[0], gc.Matches, `\|1\|.*`)
+		c.Check(entry.Comment, gc.Equals, "comment")
+	}
+	c.Check(entries[2].Hosts, jc.DeepEquals, []string{"*.internal"})
+	c.Check(k.Lookup("example.com"), gc.HasLen, 1)
+	c.Check(k.Lookup("example.com:2222"), gc.HasLen, 1)
+	c.Check(k.Lookup("example.com:2223"), gc.HasLen, 0)
+}
+
+func (s *KnownHostsSuite) TestUpdateKnownHosts(c *gc.C) {
+	keyOne := parsePublicKey(c, sshtesting.ValidKeyOne.Key)
+	err := ssh.UpdateKnownHosts(s.path, func(k *ssh.KnownHosts) error {
+		k.Add("example.com", keyOne)
+		return nil
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := os.ReadFile(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Equals, "example.com "+sshtesting.ValidKeyOne.Key+"\n")
+	info, err := os.Stat(s.path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0600))
+}
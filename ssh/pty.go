@@ -0,0 +1,130 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh
+
+import (
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/juju/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// defaultTerm is the terminal type requested
+// for a PTY if $TERM is not set.
+const defaultTerm = "xterm"
+
+// The size of the PTY requested when stdin is not a terminal.
+const (
+	defaultTermWidth  = 80
+	defaultTermHeight = 24
+)
+
+// Terminal operations, which may be patched out by tests.
+var (
+	isTerminal      = terminal.IsTerminal
+	getTerminalSize = terminal.GetSize
+	makeTerminalRaw = func(fd int) (func(), error) {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return nil, err
+		}
+		return func() { terminal.Restore(fd, state) }, nil
+	}
+)
+
+// terminalFd returns the file descriptor underlying r,
+// and whether it refers to a terminal.
+func terminalFd(r io.Reader) (int, bool) {
+	f, ok := r.(interface{ Fd() uintptr })
+	if !ok {
+		return 0, false
+	}
+	fd := int(f.Fd())
+	return fd, isTerminal(fd)
+}
+
+// requestPTY requests a PTY for the session, sized to match the local
+// terminal. If stdin is a terminal, it is put into raw mode so that
+// keystrokes are passed straight through to the remote program, and
+// changes to its size are propagated until the command is closed.
+func (c *goCryptoCommand) requestPTY(sess *ssh.Session) error {
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = defaultTerm
+	}
+	width, height := defaultTermWidth, defaultTermHeight
+	fd, isTerm := terminalFd(c.stdin)
+	if isTerm {
+		if w, h, err := getTerminalSize(fd); err == nil {
+			width, height = w, h
+		}
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := sess.RequestPty(term, height, width, modes); err != nil {
+		return errors.Annotate(err, "requesting pty")
+	}
+	if !isTerm {
+		return nil
+	}
+	restore, err := makeTerminalRaw(fd)
+	if err != nil {
+		return errors.Annotate(err, "setting terminal to raw mode")
+	}
+	c.restoreTerminal = restore
+	resized := make(chan os.Signal, 1)
+	notifyWindowChange(resized)
+	c.stopWindowChange = make(chan struct{})
+	c.windowChangeDone = make(chan struct{})
+	go func() {
+		defer close(c.windowChangeDone)
+		defer signal.Stop(resized)
+		watchWindowSize(fd, sess, resized, c.stopWindowChange)
+	}()
+	return nil
+}
+
+// watchWindowSize propagates changes to the size of the local
+// terminal, signalled on resized, to the session's PTY, until
+// stop is closed.
+func watchWindowSize(fd int, sess *ssh.Session, resized <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-resized:
+		}
+		width, height, err := getTerminalSize(fd)
+		if err != nil {
+			logger.Debugf("cannot get terminal size: %v", err)
+			continue
+		}
+		if err := sess.WindowChange(height, width); err != nil {
+			logger.Debugf("cannot change window size: %v", err)
+			return
+		}
+	}
+}
+
+// stopPTY stops propagating changes to the size of the local
+// terminal, waiting for any change in progress to finish, and
+// restores it from raw mode.
+func (c *goCryptoCommand) stopPTY() {
+	if c.stopWindowChange != nil {
+		close(c.stopWindowChange)
+		<-c.windowChangeDone
+		c.stopWindowChange = nil
+		c.windowChangeDone = nil
+	}
+	if c.restoreTerminal != nil {
+		c.restoreTerminal()
+		c.restoreTerminal = nil
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !windows
+// +build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyWindowChange arranges for c to receive a
+// signal whenever the terminal is resized.
+var notifyWindowChange = func(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
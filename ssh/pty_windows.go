@@ -0,0 +1,12 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssh
+
+import (
+	"os"
+)
+
+// notifyWindowChange does nothing on windows, where there
+// is no signal to indicate that the console was resized.
+var notifyWindowChange = func(c chan<- os.Signal) {}
@@ -5,15 +5,17 @@ package ssh
 
 import (
 	"bytes"
-	"os/exec"
+	"io"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/juju/clock"
+	"github.com/juju/collections/set"
 	"github.com/juju/errors"
 
 	utilexec "github.com/juju/utils/v4/exec"
+	"github.com/juju/utils/v4/parallel"
 )
 
 // ExecParams are used for the parameters for ExecuteCommandOnMachine.
@@ -22,6 +24,25 @@ type ExecParams struct {
 	Host         string
 	Command      string
 	Timeout      time.Duration
+
+	// Stdout and Stderr, if not nil, receive the
+	// command's output as it arrives.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// OnStdoutLine and OnStderrLine, if not nil, are called with
+	// each line of the command's output, without the trailing
+	// newline, as it arrives. Any incomplete final line is passed
+	// when the command completes. The two functions may be called
+	// concurrently.
+	OnStdoutLine func(line string)
+	OnStderrLine func(line string)
+
+	// MaxOutputSize, if positive, is the maximum number of bytes
+	// of each of stdout and stderr kept for the ExecResponse.
+	// Any further output is still passed to the writers and
+	// functions above.
+	MaxOutputSize int
 }
 
 // StartCommandOnMachine executes the command on the given host. The
@@ -45,8 +66,8 @@ func StartCommandOnMachine(params ExecParams) (*RunningCmd, error) {
 	running := &RunningCmd{
 		SSHCmd: command,
 	}
-	command.Stdout = &running.Stdout
-	command.Stderr = &running.Stderr
+	command.Stdout = running.outputWriter(&running.Stdout, params.Stdout, params.OnStdoutLine, params.MaxOutputSize)
+	command.Stderr = running.outputWriter(&running.Stderr, params.Stderr, params.OnStderrLine, params.MaxOutputSize)
 	command.Stdin = strings.NewReader(params.Command + "\n")
 	if err := command.Start(); err != nil {
 		return nil, errors.Trace(err)
@@ -63,6 +84,34 @@ type RunningCmd struct {
 	// Stdout and Stderr are the output streams the command is using.
 	Stdout bytes.Buffer
 	Stderr bytes.Buffer
+
+	// lineWriters holds the writers passing output lines
+	// to callbacks, which are flushed when the command
+	// completes.
+	lineWriters []*lineWriter
+}
+
+// outputWriter returns a writer that records output in buf, limited to
+// max bytes if max is positive, and also copies it to w and passes each
+// line to onLine if they are not nil.
+func (cmd *RunningCmd) outputWriter(buf *bytes.Buffer, w io.Writer, onLine func(string), max int) io.Writer {
+	var kept io.Writer = buf
+	if max > 0 {
+		kept = &limitWriter{w: buf, n: max}
+	}
+	writers := []io.Writer{kept}
+	if w != nil {
+		writers = append(writers, w)
+	}
+	if onLine != nil {
+		lw := &lineWriter{line: onLine}
+		cmd.lineWriters = append(cmd.lineWriters, lw)
+		writers = append(writers, lw)
+	}
+	if len(writers) == 1 {
+		return kept
+	}
+	return io.MultiWriter(writers...)
 }
 
 // Wait waits for the command to complete and returns the result.
@@ -75,6 +124,9 @@ func (cmd *RunningCmd) Wait() (result utilexec.ExecResponse, _ error) {
 
 	err := cmd.SSHCmd.Wait()
 	logger.Debugf("command.Wait finished (err: %v)", err)
+	for _, lw := range cmd.lineWriters {
+		lw.Flush()
+	}
 	code, err := getExitCode(err)
 	if err != nil {
 		return result, errors.Trace(err)
@@ -125,16 +177,9 @@ func getExitCode(err error) (int, error) {
 	if err == nil {
 		return 0, nil
 	}
-	err = errors.Cause(err)
-	if ee, ok := err.(*exec.ExitError); ok {
-		raw := ee.ProcessState.Sys()
-		status, ok := raw.(syscall.WaitStatus)
-		if !ok {
-			logger.Errorf("unexpected type %T from ProcessState.Sys()", raw)
-		} else if status.Exited() {
-			// A non-zero return code isn't considered an error here.
-			return status.ExitStatus(), nil
-		}
+	if ee, ok := errors.Cause(err).(*ExitError); ok && ee.ExitStatus() >= 0 {
+		// A non-zero return code isn't considered an error here.
+		return ee.ExitStatus(), nil
 	}
 	return -1, err
 }
@@ -164,3 +209,152 @@ func ExecuteCommandOnMachine(args ExecParams) (utilexec.ExecResponse, error) {
 
 	return result, nil
 }
+
+// MultiExecParams are used for the parameters for
+// ExecuteCommandOnMachines.
+type MultiExecParams struct {
+	// ExecParams holds the parameters used for each host.
+	// The Host field is ignored, and Timeout applies to each
+	// host separately. Each line of output written to Stdout
+	// and Stderr or passed to OnStdoutLine and OnStderrLine
+	// is prefixed by the host's name, and lines from different
+	// hosts are never interleaved.
+	ExecParams
+
+	// Hosts holds the hosts to run the command on.
+	Hosts []string
+
+	// MaxConcurrency is the maximum number of hosts to run
+	// the command on at once. If it is zero, the command is
+	// run on all of the hosts at once.
+	MaxConcurrency int
+}
+
+// HostResult holds the result of running a command on a host.
+type HostResult struct {
+	Response utilexec.ExecResponse
+	Error    error
+}
+
+// ExecuteCommandOnMachines executes the command passed through on each
+// of the hosts specified, in the same way as ExecuteCommandOnMachine.
+// The result for each host is returned, keyed by host. If the command
+// failed on any host, a parallel.Errors value holding the error for each
+// of those hosts is also returned.
+func ExecuteCommandOnMachines(params MultiExecParams) (map[string]HostResult, error) {
+	seen := set.NewStrings()
+	for _, host := range params.Hosts {
+		if seen.Contains(host) {
+			return nil, errors.NotValidf("duplicate host %q", host)
+		}
+		seen.Add(host)
+	}
+	max := params.MaxConcurrency
+	if max <= 0 {
+		max = len(params.Hosts)
+	}
+	if max == 0 {
+		return map[string]HostResult{}, nil
+	}
+
+	var (
+		mu       sync.Mutex
+		results  = make(map[string]HostResult)
+		outputMu sync.Mutex
+	)
+	run := parallel.NewRun(max)
+	for _, host := range params.Hosts {
+		host := host
+		run.Do(func() error {
+			args := params.ExecParams
+			args.Host = host
+			args.Stdout, args.Stderr = nil, nil
+			args.OnStdoutLine = prefixLines(host, params.Stdout, params.OnStdoutLine, &outputMu)
+			args.OnStderrLine = prefixLines(host, params.Stderr, params.OnStderrLine, &outputMu)
+			response, err := ExecuteCommandOnMachine(args)
+
+			mu.Lock()
+			results[host] = HostResult{Response: response, Error: err}
+			mu.Unlock()
+			return errors.Annotatef(err, "%s", host)
+		})
+	}
+	err := run.Wait()
+	return results, err
+}
+
+// prefixLines returns a function that prefixes each line with the
+// host's name, then writes it to w and passes it to onLine if they
+// are not nil. Calls to w and onLine are serialised with mu.
+func prefixLines(host string, w io.Writer, onLine func(string), mu *sync.Mutex) func(string) {
+	if w == nil && onLine == nil {
+		return nil
+	}
+	return func(line string) {
+		line = host + ": " + line
+		mu.Lock()
+		defer mu.Unlock()
+		if w != nil {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				logger.Debugf("cannot write output of %s: %v", host, err)
+			}
+		}
+		if onLine != nil {
+			onLine(line)
+		}
+	}
+}
+
+// lineWriter calls a function with each line written to it,
+// without the trailing newline.
+type lineWriter struct {
+	line func(string)
+	buf  []byte
+}
+
+// Write implements io.Writer. Incomplete lines are
+// buffered until they are completed or flushed.
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush passes on any incomplete line.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// limitWriter writes at most n bytes to w,
+// silently discarding anything further.
+type limitWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write implements io.Writer.
+func (w *limitWriter) Write(data []byte) (int, error) {
+	if w.n <= 0 {
+		return len(data), nil
+	}
+	kept := data
+	if len(kept) > w.n {
+		kept = kept[:w.n]
+	}
+	n, err := w.w.Write(kept)
+	w.n -= n
+	if err != nil {
+		return n, err
+	}
+	return len(data), nil
+}
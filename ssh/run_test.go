@@ -4,16 +4,20 @@
 package ssh_test
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"time"
 
+	"github.com/juju/errors"
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
+	"github.com/juju/utils/v4/parallel"
 	"github.com/juju/utils/v4/ssh"
 )
 
@@ -114,6 +118,87 @@ func (s *ExecuteSSHCommandSuite) TestCapturesReturnCode(c *gc.C) {
 	c.Assert(string(response.Stderr), gc.Equals, "")
 }
 
+func (s *ExecuteSSHCommandSuite) TestStreamOutput(c *gc.C) {
+	s.fakeSSH(c, passthroughSSH)
+	flag := filepath.Join(c.MkDir(), "flag")
+
+	lines := make(chan string, 10)
+	var stdout bytes.Buffer
+	cmd, err := ssh.StartCommandOnMachine(ssh.ExecParams{
+		Host: "hostname",
+		Command: fmt.Sprintf(`echo first
+echo error >&2
+while [ ! -e %s ]; do sleep 0.01; done
+echo second
+printf partial`, flag),
+		Stdout:       &stdout,
+		OnStdoutLine: func(line string) { lines <- line },
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The first line arrives while the command is still running.
+	select {
+	case line := <-lines:
+		c.Assert(line, gc.Equals, "first")
+	case <-time.After(longWait):
+		c.Fatalf("timed out waiting for output")
+	}
+	c.Assert(stdout.String(), gc.Equals, "first\n")
+	err = ioutil.WriteFile(flag, nil, 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	response, err := cmd.Wait()
+	c.Assert(err, jc.ErrorIsNil)
+	close(lines)
+	var rest []string
+	for line := range lines {
+		rest = append(rest, line)
+	}
+	c.Assert(rest, jc.DeepEquals, []string{"second", "partial"})
+	c.Assert(stdout.String(), gc.Equals, "first\nsecond\npartial")
+	c.Assert(string(response.Stdout), gc.Equals, "first\nsecond\npartial")
+	c.Assert(string(response.Stderr), gc.Equals, "error\n")
+}
+
+func (s *ExecuteSSHCommandSuite) TestMaxOutputSize(c *gc.C) {
+	s.fakeSSH(c, passthroughSSH)
+
+	var stdoutLines, stderrLines []string
+	response, err := ssh.ExecuteCommandOnMachine(ssh.ExecParams{
+		Host:          "hostname",
+		Command:       "for i in 1 2 3 4; do echo line$i; echo err$i >&2; done",
+		Timeout:       longWait,
+		OnStdoutLine:  func(line string) { stdoutLines = append(stdoutLines, line) },
+		OnStderrLine:  func(line string) { stderrLines = append(stderrLines, line) },
+		MaxOutputSize: 8,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(response.Stdout), gc.Equals, "line1\nli")
+	c.Assert(string(response.Stderr), gc.Equals, "err1\nerr")
+	c.Assert(stdoutLines, jc.DeepEquals, []string{"line1", "line2", "line3", "line4"})
+	c.Assert(stderrLines, jc.DeepEquals, []string{"err1", "err2", "err3", "err4"})
+}
+
+func (s *ExecuteSSHCommandSuite) TestExecuteCommandOnMachinesLines(c *gc.C) {
+	s.fakeSSH(c, hostSSH)
+
+	var lines []string
+	_, err := ssh.ExecuteCommandOnMachines(ssh.MultiExecParams{
+		ExecParams: ssh.ExecParams{
+			Command:      "echo $HOST; echo done",
+			Timeout:      longWait,
+			OnStdoutLine: func(line string) { lines = append(lines, line) },
+		},
+		Hosts: []string{"one", "two", "three"},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(lines, jc.SameContents, []string{
+		"one: one", "one: done",
+		"two: two", "two: done",
+		"three: three", "three: done",
+	})
+}
+
 // echoSSH outputs the command args to stderr, and copies stdin to stdout
 var echoSSH = `#!/bin/bash
 # Write the args to stderr
@@ -130,3 +215,86 @@ sleep 5s
 
 // passthroughSSH creates an ssh that executes stdin.
 var passthroughSSH = `#!/bin/bash -s`
+
+// hostSSH runs stdin with $HOST set to the host being connected to.
+var hostSSH = `#!/bin/bash
+export HOST=${@: -3:1}
+exec /bin/bash -s
+`
+
+const hostCommand = `echo "hello from $HOST"
+printf "partial"
+[ $HOST = bad ] && echo "oops" >&2 && exit 3
+[ $HOST = slow ] && exec sleep 10
+true
+`
+
+func (s *ExecuteSSHCommandSuite) TestExecuteCommandOnMachines(c *gc.C) {
+	s.fakeSSH(c, hostSSH)
+
+	var stdout, stderr bytes.Buffer
+	results, err := ssh.ExecuteCommandOnMachines(ssh.MultiExecParams{
+		ExecParams: ssh.ExecParams{
+			Command: hostCommand,
+			Timeout: 2 * time.Second,
+			Stdout:  &stdout,
+			Stderr:  &stderr,
+		},
+		Hosts:          []string{"one", "bad", "slow", "two"},
+		MaxConcurrency: 1,
+	})
+	c.Assert(err, gc.FitsTypeOf, parallel.Errors{})
+	c.Assert(err, gc.ErrorMatches, "slow: command timed out")
+	c.Assert(results, gc.HasLen, 4)
+
+	for _, host := range []string{"one", "two"} {
+		c.Check(results[host].Error, jc.ErrorIsNil)
+		c.Check(results[host].Response.Code, gc.Equals, 0)
+		c.Check(string(results[host].Response.Stdout), gc.Equals, "hello from "+host+"\npartial")
+	}
+	c.Check(results["bad"].Error, jc.ErrorIsNil)
+	c.Check(results["bad"].Response.Code, gc.Equals, 3)
+	c.Check(string(results["bad"].Response.Stderr), gc.Equals, "oops\n")
+	c.Check(errors.Cause(results["slow"].Error), gc.Equals, ssh.Cancelled)
+	c.Check(string(results["slow"].Response.Stdout), gc.Equals, "hello from slow\npartial")
+
+	// With one host at a time, the output of each host is together.
+	c.Check(stdout.String(), gc.Equals, `
+one: hello from one
+one: partial
+bad: hello from bad
+bad: partial
+slow: hello from slow
+slow: partial
+two: hello from two
+two: partial
+`[1:])
+	c.Check(stderr.String(), gc.Equals, "bad: oops\n")
+}
+
+func (s *ExecuteSSHCommandSuite) TestExecuteCommandOnMachinesConcurrently(c *gc.C) {
+	s.fakeSSH(c, hostSSH)
+
+	hosts := []string{"h0", "h1", "h2", "h3", "h4", "h5"}
+	start := time.Now()
+	results, err := ssh.ExecuteCommandOnMachines(ssh.MultiExecParams{
+		ExecParams: ssh.ExecParams{
+			Command: "sleep 0.5; echo $HOST",
+			Timeout: longWait,
+		},
+		Hosts: hosts,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(time.Since(start) < 3*time.Second, jc.IsTrue)
+	c.Assert(results, gc.HasLen, len(hosts))
+	for _, host := range hosts {
+		c.Check(string(results[host].Response.Stdout), gc.Equals, host+"\n")
+	}
+}
+
+func (s *ExecuteSSHCommandSuite) TestExecuteCommandOnMachinesDuplicateHost(c *gc.C) {
+	_, err := ssh.ExecuteCommandOnMachines(ssh.MultiExecParams{
+		Hosts: []string{"one", "two", "one"},
+	})
+	c.Assert(err, gc.ErrorMatches, `duplicate host "one" not valid`)
+}
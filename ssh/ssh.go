@@ -8,13 +8,11 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"io"
-	"os/exec"
-	"syscall"
+	"time"
 
 	"github.com/juju/errors"
-
-	"github.com/juju/utils/v4"
 )
 
 // StrictHostChecksOption defines the possible values taken by
@@ -46,26 +44,65 @@ const (
 	StrictHostChecksAsk
 )
 
+const (
+	// defaultServerAliveInterval is the interval at which keepalive
+	// messages are sent to the server if no interval is specified.
+	// We must send keepalives or the server may think we've become
+	// unresponsive on long running command executions such as
+	// "apt-get upgrade".
+	defaultServerAliveInterval = 30 * time.Second
+
+	// defaultServerAliveCountMax is the number of keepalive messages
+	// that may go unanswered before the connection is considered
+	// dead, if no count is specified. This matches OpenSSH.
+	defaultServerAliveCountMax = 3
+)
+
 // Options is a client-implementation independent SSH options set.
 type Options struct {
 	// proxyCommand specifies the command to
 	// execute to proxy SSH traffic through.
 	proxyCommand []string
 
+	// jumpHosts specifies a sequence of hosts, in the format
+	// [user@]host[:port], to connect through in turn before
+	// connecting to the target host.
+	jumpHosts []string
+
 	// ssh server port; zero means use the default (22)
 	port int
 
+	// connectTimeout is the maximum time to wait for the connection
+	// to the server to be established; zero means no timeout.
+	connectTimeout time.Duration
+
+	// serverAliveInterval is the interval at which keepalive messages
+	// are sent to the server; zero means use the default.
+	serverAliveInterval time.Duration
+
+	// serverAliveCountMax is the number of keepalive messages that may
+	// go unanswered before disconnecting; zero means use the default.
+	serverAliveCountMax int
+
 	// no PTY forced by default
 	allocatePTY bool
 
 	// password authentication is disallowed by default
 	passwordAuthAllowed bool
 
+	// no agent forwarding by default
+	forwardAgent bool
+
 	// identities is a sequence of paths to private key/identity files
 	// to use when attempting to login. A client implementaton may attempt
 	// with additional identities, but must give preference to these
 	identities []string
 
+	// certificates is a sequence of paths to OpenSSH user certificate
+	// files to use, along with the matching private keys, when
+	// attempting to login.
+	certificates []string
+
 	// knownHostsFile is a path to a file in which to save the host's
 	// fingerprint.
 	knownHostsFile string
@@ -78,6 +115,10 @@ type Options struct {
 	// accept from the server, in order of preference. By default the
 	// client implementation will specify a set of reasonable types.
 	hostKeyAlgorithms []string
+
+	// auditHook, if not nil, is notified of commands
+	// executed and copies made with these options.
+	auditHook AuditHook
 }
 
 // SetProxyCommand sets a command to execute to proxy traffic through.
@@ -85,11 +126,37 @@ func (o *Options) SetProxyCommand(command ...string) {
 	o.proxyCommand = append([]string{}, command...)
 }
 
+// SetJumpHosts sets a sequence of hosts to connect through, in order,
+// before connecting to the target host. Each host is specified in the
+// format [user@]host[:port]. Host keys are checked for each hop in the
+// same way as for the target host.
+//
+// If jump hosts are set, any proxy command is ignored.
+func (o *Options) SetJumpHosts(hosts ...string) {
+	o.jumpHosts = append([]string{}, hosts...)
+}
+
 // SetPort sets the SSH server port to connect to.
 func (o *Options) SetPort(port int) {
 	o.port = port
 }
 
+// SetConnectTimeout sets the maximum time to wait for the connection
+// to the SSH server to be established, including the initial protocol
+// exchange.
+func (o *Options) SetConnectTimeout(timeout time.Duration) {
+	o.connectTimeout = timeout
+}
+
+// SetServerAlive sets the interval at which keepalive messages are
+// sent to the SSH server, and the number of consecutive messages that
+// may go unanswered before the connection is considered dead and is
+// closed. Zero values select the defaults of 30 seconds and 3 messages.
+func (o *Options) SetServerAlive(interval time.Duration, countMax int) {
+	o.serverAliveInterval = interval
+	o.serverAliveCountMax = countMax
+}
+
 // EnablePTY forces the allocation of a pseudo-TTY.
 //
 // Forcing a pseudo-TTY is required, for example, for sudo
@@ -98,6 +165,17 @@ func (o *Options) EnablePTY() {
 	o.allocatePTY = true
 }
 
+// EnableAgentForwarding forwards the connection to the local
+// authentication agent, identified by $SSH_AUTH_SOCK, to the
+// target host.
+//
+// Agent forwarding should be enabled with caution, as users
+// able to bypass file permissions on the target host can use
+// the forwarded agent to authenticate as the local user.
+func (o *Options) EnableAgentForwarding() {
+	o.forwardAgent = true
+}
+
 // SetKnownHostsFile sets the host's fingerprint to be saved in the given file.
 //
 // Host fingerprints are saved in ~/.ssh/known_hosts by default.
@@ -128,6 +206,13 @@ func (o *Options) SetIdentities(identityFiles ...string) {
 	o.identities = append([]string{}, identityFiles...)
 }
 
+// SetCertificates sets a sequence of paths to OpenSSH user certificate
+// files to use when attempting login. Each certificate is used with the
+// private key whose public key it certifies.
+func (o *Options) SetCertificates(certificateFiles ...string) {
+	o.certificates = append([]string{}, certificateFiles...)
+}
+
 // SetHostKeyAlgorithms sets the host key types that the client will
 // accept from the server, in order of preference. If not specified,
 // the client implementation may choose its own defaults.
@@ -135,6 +220,13 @@ func (o *Options) SetHostKeyAlgorithms(algos ...string) {
 	o.hostKeyAlgorithms = algos
 }
 
+// SetAuditHook sets the hook notified of commands executed, and
+// copies made, with these options. If hook is nil, the hook set
+// with SetDefaultAuditHook is used.
+func (o *Options) SetAuditHook(hook AuditHook) {
+	o.auditHook = hook
+}
+
 // Client is an interface for SSH clients to implement
 type Client interface {
 	// Command returns a Command for executing a command
@@ -158,6 +250,7 @@ type Cmd struct {
 	Stdout io.Writer
 	Stderr io.Writer
 	impl   command
+	audit  audit
 }
 
 func newCmd(impl command) *Cmd {
@@ -194,18 +287,61 @@ func (c *Cmd) Output() ([]byte, error) {
 }
 
 // Run runs the command, and returns the result as an error.
+// If the command exits with a non-zero status, the error is a
+// *utils.RcPassthroughError holding that status. If the connection
+// to the remote host fails, or the command does not complete
+// successfully for another reason, the error is an *ExitError.
+//
+// Use Start and Wait to get an *ExitError in all cases.
 func (c *Cmd) Run() error {
 	if err := c.Start(); err != nil {
 		return err
 	}
-	err := c.Wait()
-	if exitError, ok := err.(*exec.ExitError); ok && exitError != nil {
-		status := exitError.ProcessState.Sys().(syscall.WaitStatus)
-		if status.Exited() {
-			return utils.NewRcPassthroughError(status.ExitStatus())
+	return rcPassthroughError(c.Wait())
+}
+
+// RunContext runs the command, and returns the result as an error,
+// as for Run. If the context is done before the command completes,
+// the command is killed, and the context's error is returned.
+func (c *Cmd) RunContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.impl.SetStdio(c.Stdin, c.Stdout, c.Stderr)
+	contextImpl, isContextImpl := c.impl.(contextCommand)
+	c.audit.started()
+	var err error
+	if isContextImpl {
+		err = contextImpl.StartContext(ctx)
+	} else {
+		err = c.impl.Start()
+	}
+	if err != nil {
+		c.audit.finished(err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	select {
+	case err := <-done:
+		return rcPassthroughError(err)
+	case <-ctx.Done():
+		// Commands started with a context terminate
+		// themselves when the context is done.
+		if !isContextImpl {
+			if err := c.impl.Kill(); err != nil {
+				logger.Debugf("killing command: %v", err)
+			}
 		}
+		<-done
+		return ctx.Err()
 	}
-	return err
 }
 
 // Start starts the command running, but does not wait for
@@ -213,13 +349,21 @@ func (c *Cmd) Run() error {
 // error is returned.
 func (c *Cmd) Start() error {
 	c.impl.SetStdio(c.Stdin, c.Stdout, c.Stderr)
-	return c.impl.Start()
+	c.audit.started()
+	err := c.impl.Start()
+	if err != nil {
+		c.audit.finished(err)
+	}
+	return err
 }
 
 // Wait waits for the started command to complete,
-// and returns the result as an error.
+// and returns the result as an error. If the command does
+// not complete successfully, the error is an *ExitError.
 func (c *Cmd) Wait() error {
-	return c.impl.Wait()
+	err := c.impl.Wait()
+	c.audit.finished(err)
+	return err
 }
 
 // Kill kills the started command.
@@ -275,6 +419,13 @@ type command interface {
 	StderrPipe() (io.ReadCloser, io.Writer, error)
 }
 
+// contextCommand is implemented by commands that can be started
+// with a context, and which terminate when the context is done.
+type contextCommand interface {
+	command
+	StartContext(ctx context.Context) error
+}
+
 // DefaultClient is the default SSH client for the process.
 //
 // If the OpenSSH client is found in $PATH, then it will be
@@ -4,7 +4,7 @@
 package ssh
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,8 +19,8 @@ import (
 
 	"github.com/juju/clock"
 	"github.com/juju/errors"
-	"github.com/juju/mutex/v2"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/crypto/ssh/terminal"
 
@@ -32,10 +32,16 @@ const sshDefaultPort = 22
 // GoCryptoClient is an implementation of Client that
 // uses the embedded go.crypto/ssh SSH client.
 //
-// GoCryptoClient is intentionally limited in the
-// functionality that it enables, as it is currently
-// intended to be used only for non-interactive command
-// execution.
+// If $SSH_AUTH_SOCK identifies a running ssh-agent, the
+// keys held by the agent are also used for authentication.
+//
+// If a PTY is requested with Options.EnablePTY and stdin is a
+// terminal, the terminal is put into raw mode and changes to its
+// size are propagated to the remote host, so that interactive
+// programs can be used.
+//
+// GoCryptoClient is otherwise intentionally limited in the
+// functionality that it enables.
 type GoCryptoClient struct {
 	signers []ssh.Signer
 }
@@ -57,7 +63,14 @@ func (c *GoCryptoClient) Command(host string, command []string, options *Options
 	}
 	user, host := splitUserHost(host)
 	port := sshDefaultPort
+	var connectTimeout time.Duration
+	serverAliveInterval := defaultServerAliveInterval
+	serverAliveCountMax := defaultServerAliveCountMax
 	var proxyCommand []string
+	var jumpHosts []string
+	var forwardAgent bool
+	var certificates []string
+	var allocatePTY bool
 	var knownHostsFile string
 	var strictHostKeyChecking StrictHostChecksOption
 	var hostKeyAlgorithms []string
@@ -65,29 +78,55 @@ func (c *GoCryptoClient) Command(host string, command []string, options *Options
 		if options.port != 0 {
 			port = options.port
 		}
+		connectTimeout = options.connectTimeout
+		if options.serverAliveInterval > 0 {
+			serverAliveInterval = options.serverAliveInterval
+		}
+		if options.serverAliveCountMax > 0 {
+			serverAliveCountMax = options.serverAliveCountMax
+		}
 		proxyCommand = options.proxyCommand
+		jumpHosts = options.jumpHosts
+		forwardAgent = options.forwardAgent
+		certificates = options.certificates
+		allocatePTY = options.allocatePTY
 		knownHostsFile = options.knownHostsFile
 		strictHostKeyChecking = options.strictHostKeyChecking
 		hostKeyAlgorithms = options.hostKeyAlgorithms
 	}
 	logger.Tracef(`running (equivalent of): ssh "%s@%s" -p %d '%s'`, user, host, port, shellCommand)
-	return &Cmd{impl: &goCryptoCommand{
-		signers:               signers,
-		user:                  user,
-		addr:                  net.JoinHostPort(host, strconv.Itoa(port)),
-		command:               shellCommand,
-		proxyCommand:          proxyCommand,
-		knownHostsFile:        knownHostsFile,
-		strictHostKeyChecking: strictHostKeyChecking,
-		hostKeyAlgorithms:     hostKeyAlgorithms,
-	}}
+	return &Cmd{
+		impl: &goCryptoCommand{
+			signers:               signers,
+			user:                  user,
+			addr:                  net.JoinHostPort(host, strconv.Itoa(port)),
+			command:               shellCommand,
+			connectTimeout:        connectTimeout,
+			serverAliveInterval:   serverAliveInterval,
+			serverAliveCountMax:   serverAliveCountMax,
+			proxyCommand:          proxyCommand,
+			jumpHosts:             jumpHosts,
+			forwardAgent:          forwardAgent,
+			certificates:          certificates,
+			allocatePTY:           allocatePTY,
+			knownHostsFile:        knownHostsFile,
+			strictHostKeyChecking: strictHostKeyChecking,
+			hostKeyAlgorithms:     hostKeyAlgorithms,
+			clock:                 clock.WallClock,
+		},
+		audit: newAudit(user, host, command, options),
+	}
 }
 
 // Copy implements Client.Copy.
 //
 // Copy is currently unimplemented, and will always return an error.
 func (c *GoCryptoClient) Copy(args []string, options *Options) error {
-	return errors.Errorf("scp command is not implemented (OpenSSH scp not available in PATH)")
+	audit := newCopyAudit(args, options)
+	audit.started()
+	err := errors.Errorf("scp command is not implemented (OpenSSH scp not available in PATH)")
+	audit.finished(err)
+	return err
 }
 
 type goCryptoCommand struct {
@@ -95,22 +134,91 @@ type goCryptoCommand struct {
 	user                  string
 	addr                  string
 	command               string
+	connectTimeout        time.Duration
+	serverAliveInterval   time.Duration
+	serverAliveCountMax   int
 	proxyCommand          []string
+	jumpHosts             []string
+	forwardAgent          bool
+	certificates          []string
+	allocatePTY           bool
 	knownHostsFile        string
 	strictHostKeyChecking StrictHostChecksOption
 	hostKeyAlgorithms     []string
+	clock                 clock.Clock
 	stdin                 io.Reader
 	stdout                io.Writer
 	stderr                io.Writer
 	client                *ssh.Client
+	jumpClients           []*ssh.Client
+	agentConn             net.Conn
 	sess                  *ssh.Session
+
+	// ctx is the context the command was started with, if any.
+	ctx context.Context
+
+	// stopContext stops the command being terminated
+	// when ctx is done, once the command has completed.
+	stopContext func() bool
+
+	// stopKeepAlive is closed to stop sending keepalives.
+	stopKeepAlive chan struct{}
+
+	// restoreTerminal restores the local terminal
+	// to its state before it was put into raw mode.
+	restoreTerminal func()
+
+	// stopWindowChange is closed to stop propagating
+	// changes in the local terminal's size.
+	stopWindowChange chan struct{}
+
+	// windowChangeDone is closed once changes in the
+	// local terminal's size are no longer propagated.
+	windowChangeDone chan struct{}
 }
 
-var sshDial = ssh.Dial
+// sshDial connects to the SSH server at the given address. The
+// connection, including the SSH handshake, is bounded by the config's
+// Timeout, and is abandoned if the context is done first.
+var sshDial = func(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return sshHandshake(ctx, conn, addr, config)
+}
 
-var sshDialWithProxy = func(addr string, proxyCommand []string, config *ssh.ClientConfig) (*ssh.Client, error) {
+// sshHandshake establishes an SSH client connection over conn. The
+// handshake is bounded by the config's Timeout, and is abandoned if
+// the context is done first.
+func sshHandshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.Timeout > 0 {
+		// Not all connections support deadlines,
+		// in which case the timeout is not applied.
+		_ = conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		// The context is done, and the connection has
+		// been closed, even if the handshake completed.
+		if err == nil {
+			sshConn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+var sshDialWithProxy = func(ctx context.Context, addr string, proxyCommand []string, config *ssh.ClientConfig) (*ssh.Client, error) {
 	if len(proxyCommand) == 0 {
-		return sshDial("tcp", addr, config)
+		return sshDial(ctx, "tcp", addr, config)
 	}
 	// User has specified a proxy. Create a pipe and
 	// redirect the proxy command's stdin/stdout to it.
@@ -135,47 +243,188 @@ var sshDialWithProxy = func(addr string, proxyCommand []string, config *ssh.Clie
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
-	conn, chans, reqs, err := ssh.NewClientConn(client, addr, config)
+	return sshHandshake(ctx, client, addr, config)
+}
+
+// sshDialVia connects to addr by tunnelling through
+// an existing client connection.
+func sshDialVia(ctx context.Context, via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	return ssh.NewClient(conn, chans, reqs), nil
+	return sshHandshake(ctx, conn, addr, config)
+}
+
+// splitJumpHost splits a jump host specified in the format
+// [user@]host[:port] into its user and host:port address.
+func splitJumpHost(s string) (user, addr string) {
+	user, hostPort := splitUserHost(s)
+	if _, _, err := net.SplitHostPort(hostPort); err == nil {
+		return user, hostPort
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(hostPort, "["), "]")
+	return user, net.JoinHostPort(host, strconv.Itoa(sshDefaultPort))
+}
+
+// dial connects to the target host, either directly (or through the
+// proxy command), or by way of each of the jump hosts in turn. The
+// clients for any jump hosts are returned so they can be closed
+// along with the target host's client.
+func (c *goCryptoCommand) dial(config *ssh.ClientConfig) (*ssh.Client, []*ssh.Client, error) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if len(c.jumpHosts) == 0 {
+		client, err := sshDialWithProxy(ctx, c.addr, c.proxyCommand, config)
+		return client, nil, err
+	}
+	var jumpClients []*ssh.Client
+	closeJumpClients := func() {
+		for i := len(jumpClients) - 1; i >= 0; i-- {
+			jumpClients[i].Close()
+		}
+	}
+	for _, jumpHost := range c.jumpHosts {
+		jumpUser, addr := splitJumpHost(jumpHost)
+		if jumpUser == "" {
+			var err error
+			if jumpUser, err = currentUsername(); err != nil {
+				closeJumpClients()
+				return nil, nil, err
+			}
+		}
+		jumpConfig := *config
+		jumpConfig.User = jumpUser
+		var client *ssh.Client
+		var err error
+		if len(jumpClients) == 0 {
+			client, err = sshDial(ctx, "tcp", addr, &jumpConfig)
+		} else {
+			client, err = sshDialVia(ctx, jumpClients[len(jumpClients)-1], addr, &jumpConfig)
+		}
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, errors.Annotatef(err, "connecting to jump host %q", jumpHost)
+		}
+		jumpClients = append(jumpClients, client)
+	}
+	client, err := sshDialVia(ctx, jumpClients[len(jumpClients)-1], c.addr, config)
+	if err != nil {
+		closeJumpClients()
+		return nil, nil, err
+	}
+	return client, jumpClients, nil
+}
+
+// dialAgent connects to the ssh-agent listening on the socket
+// named by $SSH_AUTH_SOCK. If the environment variable is not
+// set, dialAgent returns a nil connection and no error.
+func dialAgent() (net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil
+	}
+	return net.Dial("unix", socket)
+}
+
+func currentUsername() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", errors.Errorf("getting current user: %v", err)
+	}
+	return currentUser.Username, nil
 }
 
 func (c *goCryptoCommand) ensureSession() (*ssh.Session, error) {
 	if c.sess != nil {
 		return c.sess, nil
 	}
-	if len(c.signers) == 0 {
+	var certs []*ssh.Certificate
+	for _, filename := range c.certificates {
+		cert, err := readCertificate(filename)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		certs = append(certs, cert)
+	}
+	agentConn, err := dialAgent()
+	if err != nil {
+		logger.Debugf("cannot connect to ssh-agent: %v", err)
+	}
+	var agentClient agent.ExtendedAgent
+	if agentConn != nil {
+		agentClient = agent.NewClient(agentConn)
+	}
+	if len(c.signers) == 0 && agentClient == nil {
 		return nil, errors.Errorf("no private keys available")
 	}
+	// Make sure the agent connection is closed
+	// if a session cannot be established.
+	defer func() {
+		if c.sess == nil && agentConn != nil {
+			agentConn.Close()
+		}
+	}()
 	if c.user == "" {
-		currentUser, err := user.Current()
+		username, err := currentUsername()
 		if err != nil {
-			return nil, errors.Errorf("getting current user: %v", err)
+			return nil, err
 		}
-		c.user = currentUser.Username
+		c.user = username
 	}
 	config := &ssh.ClientConfig{
 		User:              c.user,
+		Timeout:           c.connectTimeout,
 		HostKeyCallback:   c.hostKeyCallback,
 		HostKeyAlgorithms: c.hostKeyAlgorithms,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
-				return c.signers, nil
+				signers := c.signers
+				if agentClient != nil {
+					agentSigners, err := agentClient.Signers()
+					if err != nil {
+						logger.Debugf("cannot get keys from ssh-agent: %v", err)
+					}
+					signers = append(signers[:len(signers):len(signers)], agentSigners...)
+				}
+				if len(certs) > 0 {
+					signers = certSigners(certs, signers)
+				}
+				return signers, nil
 			}),
 		},
 	}
-	client, err := sshDialWithProxy(c.addr, c.proxyCommand, config)
+	client, jumpClients, err := c.dial(config)
 	if err != nil {
-		return nil, err
+		return nil, connectionError(err)
 	}
 	sess, err := client.NewSession()
 	if err != nil {
 		client.Close()
-		return nil, err
+		for i := len(jumpClients) - 1; i >= 0; i-- {
+			jumpClients[i].Close()
+		}
+		return nil, connectionError(err)
+	}
+	if c.forwardAgent {
+		if err := c.requestAgentForwarding(client, sess); err != nil {
+			sess.Close()
+			client.Close()
+			for i := len(jumpClients) - 1; i >= 0; i-- {
+				jumpClients[i].Close()
+			}
+			return nil, err
+		}
 	}
 	c.client = client
+	c.jumpClients = jumpClients
+	c.agentConn = agentConn
+	if c.serverAliveInterval > 0 {
+		c.stopKeepAlive = make(chan struct{})
+		go keepAlive(c.clock, client, c.serverAliveInterval, c.serverAliveCountMax, c.stopKeepAlive)
+	}
 	c.sess = sess
 	c.sess.Stdin = WrapStdin(c.stdin)
 	c.sess.Stdout = c.stdout
@@ -183,18 +432,117 @@ func (c *goCryptoCommand) ensureSession() (*ssh.Session, error) {
 	return sess, nil
 }
 
+// requestAgentForwarding arranges for agent connections requested
+// by the remote host to be forwarded to the local ssh-agent.
+func (c *goCryptoCommand) requestAgentForwarding(client *ssh.Client, sess *ssh.Session) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		logger.Warningf("agent forwarding requested, but no ssh-agent is available")
+		return nil
+	}
+	if err := agent.ForwardToRemote(client, socket); err != nil {
+		return errors.Annotate(err, "forwarding ssh-agent")
+	}
+	return errors.Annotate(agent.RequestAgentForwarding(sess), "requesting agent forwarding")
+}
+
 func (c *goCryptoCommand) Start() error {
 	sess, err := c.ensureSession()
 	if err != nil {
 		return err
 	}
-	if c.command == "" {
-		return sess.Shell()
+	if c.allocatePTY {
+		err = c.requestPTY(sess)
+	}
+	if err == nil {
+		if c.command == "" {
+			err = sess.Shell()
+		} else {
+			err = sess.Start(c.command)
+		}
+	}
+	if err != nil {
+		// Close is not called if Start fails, so the keepalives,
+		// connection and local terminal must be cleaned up here.
+		c.Close()
+	}
+	return err
+}
+
+// StartContext starts the command running, arranging for it to be
+// killed and the connection closed if the context is done before the
+// command completes.
+func (c *goCryptoCommand) StartContext(ctx context.Context) error {
+	c.ctx = ctx
+	if err := c.Start(); err != nil {
+		return err
+	}
+	sess, client := c.sess, c.client
+	c.stopContext = context.AfterFunc(ctx, func() {
+		// The server may not honour the signal,
+		// so close the connection regardless.
+		sess.Signal(ssh.SIGKILL)
+		client.Close()
+	})
+	return nil
+}
+
+// keepAlive sends keepalive requests to the server every interval
+// according to clk, until stop is closed. If countMax consecutive
+// requests go unanswered, the server is considered dead and the
+// connection is closed.
+func keepAlive(clk clock.Clock, client *ssh.Client, interval time.Duration, countMax int, stop <-chan struct{}) {
+	// now is always ready, for sending the next keepalive immediately.
+	now := make(chan time.Time)
+	close(now)
+
+	var missed int
+	next := clk.After(interval)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-next:
+		}
+		replied := make(chan error, 1)
+		go func() {
+			// Any reply, even a failure, shows the server is alive.
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		timeout := clk.After(interval)
+		select {
+		case <-stop:
+			return
+		case err := <-replied:
+			if err != nil {
+				// The connection has been closed.
+				return
+			}
+			missed = 0
+			next = timeout
+		case <-timeout:
+			missed++
+			if missed >= countMax {
+				logger.Warningf("no response from server after %d keepalives, disconnecting", missed)
+				client.Close()
+				return
+			}
+			next = now
+		}
 	}
-	return sess.Start(c.command)
 }
 
 func (c *goCryptoCommand) Close() error {
+	if c.stopContext != nil {
+		c.stopContext()
+		c.stopContext = nil
+	}
+	if c.stopKeepAlive != nil {
+		close(c.stopKeepAlive)
+		c.stopKeepAlive = nil
+	}
+	c.stopPTY()
 	if c.sess == nil {
 		return nil
 	}
@@ -203,8 +551,16 @@ func (c *goCryptoCommand) Close() error {
 	if err0 == nil {
 		err0 = err1
 	}
+	for i := len(c.jumpClients) - 1; i >= 0; i-- {
+		c.jumpClients[i].Close()
+	}
+	if c.agentConn != nil {
+		c.agentConn.Close()
+	}
 	c.sess = nil
 	c.client = nil
+	c.jumpClients = nil
+	c.agentConn = nil
 	return err0
 }
 
@@ -214,7 +570,7 @@ func (c *goCryptoCommand) Wait() error {
 	}
 	err := c.sess.Wait()
 	c.Close()
-	return err
+	return goCryptoExitError(err)
 }
 
 func (c *goCryptoCommand) Kill() error {
@@ -287,6 +643,12 @@ func (c *goCryptoCommand) hostKeyCallback(hostname string, remote net.Addr, key
 	if err != nil || matched {
 		return errors.Trace(err)
 	}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		// The certificate was not signed by a trusted authority,
+		// so we fall back to verifying and recording the plain
+		// host key, as OpenSSH does.
+		key = cert.Key
+	}
 	// We did not find a matching key, so what we do next depends on the
 	// strict host key checking configuration.
 
@@ -340,32 +702,13 @@ func (c *goCryptoCommand) hostKeyCallback(hostname string, remote net.Addr, key
 	}
 
 	if knownHostsFile != os.DevNull {
-		// Make sure no other process modifies the file.
-		releaser, err := mutex.Acquire(mutex.Spec{
-			Name:  "juju-ssh-client",
-			Clock: clock.WallClock,
-			Delay: time.Second,
+		err := UpdateKnownHosts(knownHostsFile, func(k *KnownHosts) error {
+			k.Add(hostname, key)
+			return nil
 		})
 		if err != nil {
 			return errors.Trace(err)
 		}
-		defer releaser.Release()
-
-		// Write the file atomically, so the initial ReadAll above
-		// doesn't have to hold the mutex.
-		knownHostsData, err := ioutil.ReadFile(knownHostsFile)
-		if err != nil && !os.IsNotExist(err) {
-			return errors.Trace(err)
-		}
-		buf := bytes.NewBuffer(knownHostsData)
-		if len(knownHostsData) > 0 && !bytes.HasSuffix(knownHostsData, []byte("\n")) {
-			buf.WriteRune('\n')
-		}
-		buf.WriteString(knownhosts.Line([]string{hostname}, key))
-		buf.WriteRune('\n')
-		if err := utils.AtomicWriteFile(knownHostsFile, buf.Bytes(), 0600); err != nil {
-			return errors.Trace(err)
-		}
 	}
 	if warnAdd {
 		printError(fmt.Sprintf(
@@ -396,6 +739,10 @@ var getTerminal = func() (readLineWriter, func(), error) {
 // checkHostKey checks the given (hostname, address, public key) tuple
 // against the local known-hosts database, if it exists, and returns a
 // boolean indicating whether a match was found, and any errors encountered.
+//
+// If the key is a host certificate signed by an authority trusted for
+// the host with a @cert-authority line, then it is considered a match.
+// Otherwise the certified key is checked as a plain host key.
 func checkHostKey(
 	hostname string,
 	remote net.Addr,
@@ -416,6 +763,15 @@ func checkHostKey(
 		}
 		return false, errors.Trace(err)
 	}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		err := callback(hostname, remote, cert)
+		if err == nil {
+			// Certificate signed by a trusted host authority.
+			return true, nil
+		}
+		logger.Debugf("host certificate for %s not accepted, checking host key: %v", hostname, err)
+		key = cert.Key
+	}
 	err = callback(hostname, remote, key)
 	switch err := err.(type) {
 	case nil:
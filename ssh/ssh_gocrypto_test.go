@@ -5,6 +5,7 @@ package ssh_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
@@ -19,9 +20,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/juju/clock/testclock"
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/testdata"
 	gc "gopkg.in/check.v1"
 
@@ -37,6 +40,56 @@ type sshServer struct {
 	cfg      *cryptossh.ServerConfig
 	listener net.Listener
 	client   *cryptossh.Client
+
+	// agentKeys holds the keys listed by the client's
+	// forwarded agent, if agent forwarding was requested.
+	agentKeys []*agent.Key
+
+	// ptyReq holds the client's PTY request, if any.
+	ptyReq *ptyRequest
+
+	// execStarted, if not nil, is closed when the command is
+	// started, after which the server waits for the client to
+	// change the window size before completing the command.
+	execStarted chan struct{}
+
+	// windowChange holds the client's window change request,
+	// if execStarted is set.
+	windowChange *windowChangeRequest
+
+	// rejectExec, if set, causes the server to refuse
+	// to start the command.
+	rejectExec bool
+
+	// exitStatus is the status the command exits with,
+	// unless exitSignal is set.
+	exitStatus uint32
+
+	// exitSignal, if set, is the signal reported
+	// as terminating the command.
+	exitSignal string
+}
+
+// exitSignalMsg is the payload of an "exit-signal" request.
+type exitSignalMsg struct {
+	Signal     string
+	CoreDumped bool
+	Error      string
+	Lang       string
+}
+
+// ptyRequest is the payload of a "pty-req" request.
+type ptyRequest struct {
+	Term          string
+	Columns, Rows uint32
+	Width, Height uint32
+	Modes         string
+}
+
+// windowChangeRequest is the payload of a "window-change" request.
+type windowChangeRequest struct {
+	Columns, Rows uint32
+	Width, Height uint32
 }
 
 func (s *sshServer) run(errorCh chan error, done chan bool) {
@@ -88,6 +141,10 @@ func (s *sshServer) run(errorCh chan error, done chan bool) {
 						errorCh <- fmt.Errorf("no reply wanted for request %+v", req)
 						return
 					}
+					if s.rejectExec {
+						req.Reply(false, nil)
+						continue
+					}
 					n := binary.BigEndian.Uint32(req.Payload[:4])
 					command := string(req.Payload[4 : n+4])
 					if command != testCommandFlat {
@@ -99,13 +156,60 @@ func (s *sshServer) run(errorCh chan error, done chan bool) {
 						errorCh <- fmt.Errorf("error sending reply: %w", err)
 						return
 					}
+					if s.execStarted != nil {
+						close(s.execStarted)
+						for req := range reqs {
+							if req.Type != "window-change" {
+								errorCh <- fmt.Errorf("unexpected request type: %q", req.Type)
+								return
+							}
+							s.windowChange = &windowChangeRequest{}
+							if err := cryptossh.Unmarshal(req.Payload, s.windowChange); err != nil {
+								errorCh <- fmt.Errorf("parsing window change: %w", err)
+								return
+							}
+							break
+						}
+					}
 					channel.Write([]byte("abc value\n"))
-					_, err := channel.SendRequest("exit-status", false, cryptossh.Marshal(&struct{ n uint32 }{0}))
+					var err error
+					if s.exitSignal != "" {
+						_, err = channel.SendRequest("exit-signal", false, cryptossh.Marshal(&exitSignalMsg{Signal: s.exitSignal}))
+					} else {
+						_, err = channel.SendRequest("exit-status", false, cryptossh.Marshal(&struct{ n uint32 }{s.exitStatus}))
+					}
 					if err != nil {
 						errorCh <- fmt.Errorf("error sending request: %w", err)
 					}
 					return
 
+				case "pty-req":
+					s.ptyReq = &ptyRequest{}
+					if err := cryptossh.Unmarshal(req.Payload, s.ptyReq); err != nil {
+						errorCh <- fmt.Errorf("parsing pty request: %w", err)
+						return
+					}
+					req.Reply(true, nil)
+
+				case "auth-agent-req@openssh.com":
+					err = req.Reply(true, nil)
+					if err != nil {
+						errorCh <- fmt.Errorf("error sending reply: %w", err)
+						return
+					}
+					agentChannel, agentReqs, err := s.client.OpenChannel("auth-agent@openssh.com", nil)
+					if err != nil {
+						errorCh <- fmt.Errorf("opening agent channel: %w", err)
+						return
+					}
+					go cryptossh.DiscardRequests(agentReqs)
+					s.agentKeys, err = agent.NewClient(agentChannel).List()
+					agentChannel.Close()
+					if err != nil {
+						errorCh <- fmt.Errorf("listing forwarded agent keys: %w", err)
+						return
+					}
+
 				default:
 					errorCh <- fmt.Errorf("unexpected request type: %q", req.Type)
 					return
@@ -115,6 +219,67 @@ func (s *sshServer) run(errorCh chan error, done chan bool) {
 	}
 }
 
+// jumpServer is an SSH server that accepts only "direct-tcpip"
+// channels, forwarding each one on to the requested address.
+type jumpServer struct {
+	cfg      *cryptossh.ServerConfig
+	listener net.Listener
+}
+
+func (s *jumpServer) run(errorCh chan error) {
+	defer close(errorCh)
+	netconn, err := s.listener.Accept()
+	if err != nil {
+		errorCh <- fmt.Errorf("accepting connection: %w", err)
+		return
+	}
+	defer netconn.Close()
+
+	_, chans, reqs, err := cryptossh.NewServerConn(netconn, s.cfg)
+	if err != nil {
+		errorCh <- fmt.Errorf("getting ssh server connection: %w", err)
+		return
+	}
+	go cryptossh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(cryptossh.UnknownChannelType, "only direct-tcpip is supported")
+			continue
+		}
+		var payload struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := cryptossh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+			errorCh <- fmt.Errorf("parsing direct-tcpip payload: %w", err)
+			return
+		}
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+		if err != nil {
+			newChannel.Reject(cryptossh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			target.Close()
+			errorCh <- fmt.Errorf("accepting direct-tcpip channel: %w", err)
+			return
+		}
+		go cryptossh.DiscardRequests(reqs)
+		go func() {
+			defer target.Close()
+			io.Copy(target, channel)
+		}()
+		go func() {
+			defer channel.Close()
+			io.Copy(channel, target)
+		}()
+	}
+}
+
 func newClient(c *gc.C) (*ssh.GoCryptoClient, cryptossh.PublicKey) {
 	private, _, err := ssh.GenerateKey("test-client")
 	c.Assert(err, jc.ErrorIsNil)
@@ -199,6 +364,44 @@ func (s *SSHGoCryptoCommandSuite) newServer(c *gc.C, serverConfig cryptossh.Serv
 	return server, s.testPublicKeys["ed25519"]
 }
 
+func (s *SSHGoCryptoCommandSuite) newJumpServer(c *gc.C) (*jumpServer, cryptossh.PublicKey) {
+	server := &jumpServer{cfg: &cryptossh.ServerConfig{}}
+	server.cfg.AddHostKey(s.testSigners["ecdsa"])
+	var err error
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Logf("Jump server listening on %s", server.listener.Addr().String())
+
+	return server, s.testPublicKeys["ecdsa"]
+}
+
+// startAgent starts an in-memory ssh-agent holding the given keys,
+// listening on a unix socket named by $SSH_AUTH_SOCK.
+func (s *SSHGoCryptoCommandSuite) startAgent(c *gc.C, keys ...any) {
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		err := keyring.Add(agent.AddedKey{PrivateKey: key})
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	socket := filepath.Join(c.MkDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	c.Assert(err, jc.ErrorIsNil)
+	s.AddCleanup(func(*gc.C) { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	s.PatchEnvironment("SSH_AUTH_SOCK", socket)
+}
+
 func (s *SSHGoCryptoCommandSuite) TestNewGoCryptoClient(c *gc.C) {
 	_, err := ssh.NewGoCryptoClient()
 	c.Assert(err, jc.ErrorIsNil)
@@ -223,7 +426,7 @@ func (s *SSHGoCryptoCommandSuite) TestClientNoKeys(c *gc.C) {
 	err = ssh.LoadClientKeys(c.MkDir())
 	c.Assert(err, jc.ErrorIsNil)
 
-	s.PatchValue(ssh.SSHDial, func(network, address string, cfg *cryptossh.ClientConfig) (*cryptossh.Client, error) {
+	s.PatchValue(ssh.SSHDial, func(ctx context.Context, network, address string, cfg *cryptossh.ClientConfig) (*cryptossh.Client, error) {
 		return nil, errors.New("ssh.Dial failed")
 	})
 	cmd = client.Command("0.1.2.3", []string{"echo", "123"}, nil)
@@ -232,6 +435,695 @@ func (s *SSHGoCryptoCommandSuite) TestClientNoKeys(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "ssh.Dial failed")
 }
 
+func (s *SSHGoCryptoCommandSuite) TestClientAgentKeys(c *gc.C) {
+	s.startAgent(c, s.testPrivateKeys["ecdsa"])
+	client, err := ssh.NewGoCryptoClient()
+	c.Assert(err, jc.ErrorIsNil)
+
+	server, _ := s.newServer(c, cryptossh.ServerConfig{})
+	checkedKey := false
+	server.cfg.PublicKeyCallback = func(conn cryptossh.ConnMetadata, pubkey cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+		c.Check(pubkey, gc.DeepEquals, s.testPublicKeys["ecdsa"])
+		checkedKey = true
+		return nil, nil
+	}
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	out, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(out), gc.Equals, "abc value\n")
+	c.Assert(checkedKey, jc.IsTrue)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+	c.Assert(server.agentKeys, gc.HasLen, 0)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestAgentForwarding(c *gc.C) {
+	s.startAgent(c, s.testPrivateKeys["ecdsa"], s.testPrivateKeys["rsa"])
+	client, _ := newClient(c)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.EnableAgentForwarding()
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	out, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(out), gc.Equals, "abc value\n")
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+
+	c.Assert(server.agentKeys, gc.HasLen, 2)
+	c.Assert(server.agentKeys[0].Marshal(), gc.DeepEquals, s.testPublicKeys["ecdsa"].Marshal())
+	c.Assert(server.agentKeys[1].Marshal(), gc.DeepEquals, s.testPublicKeys["rsa"].Marshal())
+}
+
+func (s *SSHGoCryptoCommandSuite) TestPTY(c *gc.C) {
+	s.PatchEnvironment("TERM", "vt100")
+	client, _ := newClient(c)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.EnablePTY()
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	out, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(out), gc.Equals, "abc value\n")
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+
+	// Stdin is not a terminal, so a default sized PTY is requested.
+	c.Assert(server.ptyReq, gc.NotNil)
+	c.Assert(server.ptyReq.Term, gc.Equals, "vt100")
+	c.Assert(server.ptyReq.Columns, gc.Equals, uint32(80))
+	c.Assert(server.ptyReq.Rows, gc.Equals, uint32(24))
+}
+
+func (s *SSHGoCryptoCommandSuite) TestPTYTerminal(c *gc.C) {
+	s.PatchEnvironment("TERM", "")
+	stdin, stdinWriter, err := os.Pipe()
+	c.Assert(err, jc.ErrorIsNil)
+	defer stdin.Close()
+	defer stdinWriter.Close()
+
+	var mu sync.Mutex
+	width, height := 120, 40
+	var raw, restored bool
+	s.PatchValue(ssh.IsTerminal, func(fd int) bool {
+		return fd == int(stdin.Fd())
+	})
+	s.PatchValue(ssh.GetTerminalSize, func(int) (int, int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return width, height, nil
+	})
+	s.PatchValue(ssh.MakeTerminalRaw, func(int) (func(), error) {
+		raw = true
+		return func() { restored = true }, nil
+	})
+	notified := make(chan chan<- os.Signal, 1)
+	s.PatchValue(ssh.NotifyWindowChange, func(ch chan<- os.Signal) {
+		notified <- ch
+	})
+
+	client, _ := newClient(c)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	server.execStarted = make(chan struct{})
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.EnablePTY()
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	var stdout bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	err = cmd.Start()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(raw, jc.IsTrue)
+
+	// Resize the terminal once the command is running.
+	select {
+	case <-server.execStarted:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for command to start")
+	}
+	var resized chan<- os.Signal
+	select {
+	case resized = <-notified:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for window size to be watched")
+	}
+	mu.Lock()
+	width, height = 100, 30
+	mu.Unlock()
+	resized <- os.Interrupt
+
+	// Wait for the server to complete the command before
+	// closing stdin, so that the session can finish.
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+	stdinWriter.Close()
+	err = cmd.Wait()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(stdout.String(), gc.Equals, "abc value\n")
+	c.Assert(restored, jc.IsTrue)
+
+	c.Assert(server.ptyReq, gc.NotNil)
+	c.Assert(server.ptyReq.Term, gc.Equals, "xterm")
+	c.Assert(server.ptyReq.Columns, gc.Equals, uint32(120))
+	c.Assert(server.ptyReq.Rows, gc.Equals, uint32(40))
+	c.Assert(server.windowChange, gc.NotNil)
+	c.Assert(server.windowChange.Columns, gc.Equals, uint32(100))
+	c.Assert(server.windowChange.Rows, gc.Equals, uint32(30))
+}
+
+func (s *SSHGoCryptoCommandSuite) TestPTYTerminalStartFails(c *gc.C) {
+	stdin, stdinWriter, err := os.Pipe()
+	c.Assert(err, jc.ErrorIsNil)
+	defer stdin.Close()
+	defer stdinWriter.Close()
+
+	var raw, restored bool
+	s.PatchValue(ssh.IsTerminal, func(fd int) bool {
+		return fd == int(stdin.Fd())
+	})
+	s.PatchValue(ssh.GetTerminalSize, func(int) (int, int, error) {
+		return 80, 24, nil
+	})
+	s.PatchValue(ssh.MakeTerminalRaw, func(int) (func(), error) {
+		raw = true
+		return func() { restored = true }, nil
+	})
+	s.PatchValue(ssh.NotifyWindowChange, func(chan<- os.Signal) {})
+
+	client, _ := newClient(c)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	server.rejectExec = true
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.EnablePTY()
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	cmd.Stdin = stdin
+	err = cmd.Start()
+	c.Assert(err, gc.NotNil)
+	c.Assert(raw, jc.IsTrue)
+	c.Assert(restored, jc.IsTrue)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestStartFailsClosesConnection(c *gc.C) {
+	client, _ := newClient(c)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	server.rejectExec = true
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	err := cmd.Start()
+	c.Assert(err, gc.NotNil)
+
+	// The server finishes once the client
+	// has closed the connection.
+	select {
+	case err, ok := <-errorCh:
+		c.Assert(ok, jc.IsFalse, gc.Commentf("unexpected error: %v", err))
+	case <-time.After(testing.LongWait):
+		c.Fatalf("connection not closed")
+	}
+}
+
+func (s *SSHGoCryptoCommandSuite) TestAuditHook(c *gc.C) {
+	var recorder auditRecorder
+	client, _ := newClient(c)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.SetAuditHook(&recorder)
+	cmd := client.Command("ubuntu@127.0.0.1", testCommand, &opts)
+	_, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+
+	c.Assert(recorder.started, gc.HasLen, 1)
+	c.Assert(recorder.finished, gc.HasLen, 1)
+	c.Check(recorder.started[0].Host, gc.Equals, "127.0.0.1")
+	c.Check(recorder.started[0].User, gc.Equals, "ubuntu")
+	c.Check(recorder.started[0].Command, jc.DeepEquals, testCommand)
+	c.Check(recorder.finished[0].ExitCode, gc.Equals, 0)
+	c.Check(recorder.finished[0].Err, jc.ErrorIsNil)
+
+	// A command that cannot be started is reported as finished.
+	cmd = client.Command("127.0.0.1", testCommand, &opts)
+	s.PatchValue(ssh.SSHDial, func(ctx context.Context, network, address string, cfg *cryptossh.ClientConfig) (*cryptossh.Client, error) {
+		return nil, errors.New("ssh.Dial failed")
+	})
+	err = cmd.Run()
+	c.Assert(err, gc.ErrorMatches, "ssh.Dial failed")
+	c.Assert(recorder.finished, gc.HasLen, 2)
+	c.Check(recorder.finished[1].ExitCode, gc.Equals, -1)
+	c.Check(recorder.finished[1].Err, gc.ErrorMatches, "ssh.Dial failed")
+}
+
+func (s *SSHGoCryptoCommandSuite) TestExitError(c *gc.C) {
+	for i, test := range []struct {
+		exitStatus uint32
+		exitSignal string
+		status     int
+		signal     string
+		message    string
+	}{
+		{exitStatus: 42, status: 42, message: "exit status 42"},
+		{exitStatus: 255, status: 255, message: "exit status 255"},
+		{exitSignal: "TERM", status: -1, signal: "TERM", message: "command terminated by signal TERM"},
+	} {
+		c.Logf("test %d", i)
+		client, _ := newClient(c)
+		server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+		server.exitStatus = test.exitStatus
+		server.exitSignal = test.exitSignal
+		errorCh := make(chan error, 1)
+		done := make(chan bool)
+		go server.run(errorCh, done)
+
+		var opts ssh.Options
+		opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+		opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+		cmd := client.Command("127.0.0.1", testCommand, &opts)
+		err := cmd.Start()
+		c.Assert(err, jc.ErrorIsNil)
+		err = cmd.Wait()
+		close(done)
+		c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+		c.Assert(err, gc.FitsTypeOf, &ssh.ExitError{})
+		exitErr := err.(*ssh.ExitError)
+		c.Check(exitErr.ExitStatus(), gc.Equals, test.status)
+		c.Check(exitErr.Signal(), gc.Equals, test.signal)
+		c.Check(exitErr.IsConnectionError(), jc.IsFalse)
+		c.Check(exitErr, gc.ErrorMatches, test.message)
+	}
+}
+
+func (s *SSHGoCryptoCommandSuite) TestExitErrorConnection(c *gc.C) {
+	client, _ := newClient(c)
+	s.PatchValue(ssh.SSHDial, func(ctx context.Context, network, address string, cfg *cryptossh.ClientConfig) (*cryptossh.Client, error) {
+		return nil, errors.New("ssh.Dial failed")
+	})
+	err := client.Command("127.0.0.1", testCommand, nil).Run()
+	c.Assert(err, gc.ErrorMatches, "ssh.Dial failed")
+	c.Assert(ssh.IsExitError(err), jc.IsTrue)
+	exitErr := err.(*ssh.ExitError)
+	c.Check(exitErr.IsConnectionError(), jc.IsTrue)
+	c.Check(exitErr.ExitStatus(), gc.Equals, -1)
+	c.Check(exitErr.Signal(), gc.Equals, "")
+}
+
+// signCertificate returns a certificate for the given key,
+// signed by the test RSA key acting as certificate authority.
+func (s *SSHGoCryptoCommandSuite) signCertificate(c *gc.C, key cryptossh.PublicKey, certType uint32, principals ...string) *cryptossh.Certificate {
+	cert := &cryptossh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           "test",
+		ValidPrincipals: principals,
+		ValidBefore:     cryptossh.CertTimeInfinity,
+	}
+	err := cert.SignCert(rand.Reader, s.testSigners["rsa"])
+	c.Assert(err, jc.ErrorIsNil)
+	return cert
+}
+
+func (s *SSHGoCryptoCommandSuite) TestUserCertificate(c *gc.C) {
+	client, clientKey := newClient(c)
+	cert := s.signCertificate(c, clientKey, cryptossh.UserCert, "ubuntu")
+	certFile := filepath.Join(c.MkDir(), "id_ed25519-cert.pub")
+	err := ioutil.WriteFile(certFile, cryptossh.MarshalAuthorizedKey(cert), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	server, _ := s.newServer(c, cryptossh.ServerConfig{})
+	checker := &cryptossh.CertChecker{
+		IsUserAuthority: func(auth cryptossh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), s.testPublicKeys["rsa"].Marshal())
+		},
+	}
+	server.cfg.PublicKeyCallback = func(conn cryptossh.ConnMetadata, pubkey cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+		if _, ok := pubkey.(*cryptossh.Certificate); !ok {
+			return nil, errors.New("certificate required")
+		}
+		return checker.Authenticate(conn, pubkey)
+	}
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.SetCertificates(certFile)
+	cmd := client.Command("ubuntu@127.0.0.1", testCommand, &opts)
+	out, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(out), gc.Equals, "abc value\n")
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestUserCertificateNotCertificate(c *gc.C) {
+	client, clientKey := newClient(c)
+	certFile := filepath.Join(c.MkDir(), "id_ed25519.pub")
+	err := ioutil.WriteFile(certFile, cryptossh.MarshalAuthorizedKey(clientKey), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	var opts ssh.Options
+	opts.SetCertificates(certFile)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	_, err = cmd.Output()
+	c.Assert(err, gc.ErrorMatches, `parsing certificate file ".*": ssh-ed25519 key is not a certificate`)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestClientKeysCertificate(c *gc.C) {
+	defer ssh.ClearClientKeys()
+	clientKeysDir := c.MkDir()
+	err := ssh.LoadClientKeys(clientKeysDir)
+	c.Assert(err, jc.ErrorIsNil)
+	publicKeyData, err := ioutil.ReadFile(filepath.Join(clientKeysDir, "juju_id_ed25519.pub"))
+	c.Assert(err, jc.ErrorIsNil)
+	clientKey, _, _, _, err := cryptossh.ParseAuthorizedKey(publicKeyData)
+	c.Assert(err, jc.ErrorIsNil)
+	cert := s.signCertificate(c, clientKey, cryptossh.UserCert, "ubuntu")
+	err = ioutil.WriteFile(
+		filepath.Join(clientKeysDir, "juju_id_ed25519"+ssh.CertificateSuffix),
+		cryptossh.MarshalAuthorizedKey(cert), 0644,
+	)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ssh.LoadClientKeys(clientKeysDir)
+	c.Assert(err, jc.ErrorIsNil)
+
+	client, err := ssh.NewGoCryptoClient()
+	c.Assert(err, jc.ErrorIsNil)
+	server, _ := s.newServer(c, cryptossh.ServerConfig{})
+	var offered []string
+	server.cfg.PublicKeyCallback = func(conn cryptossh.ConnMetadata, pubkey cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+		offered = append(offered, pubkey.Type())
+		return nil, nil
+	}
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	cmd := client.Command("ubuntu@127.0.0.1", testCommand, &opts)
+	_, err = cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+	// The certificate is offered in preference to the plain key.
+	c.Assert(offered, gc.Not(gc.HasLen), 0)
+	c.Assert(offered[0], gc.Equals, cryptossh.CertAlgoED25519v01)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestHostCertificateAuthority(c *gc.C) {
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	hostCert := s.signCertificate(c, s.testPublicKeys["ed25519"], cryptossh.HostCert, "127.0.0.1")
+	hostCertSigner, err := cryptossh.NewCertSigner(hostCert, s.testSigners["ed25519"])
+	c.Assert(err, jc.ErrorIsNil)
+	server.cfg.AddHostKey(hostCertSigner)
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	knownHosts := fmt.Sprintf(
+		"@cert-authority [127.0.0.1]:%d %s",
+		server.listener.Addr().(*net.TCPAddr).Port,
+		cryptossh.MarshalAuthorizedKey(s.testPublicKeys["rsa"]),
+	)
+	err = ioutil.WriteFile(s.knownHostsFile, []byte(knownHosts), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksYes)
+	client, _ := newClient(c)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	out, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(out), gc.Equals, "abc value\n")
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+
+	// The host was trusted by way of the authority,
+	// so the known_hosts file is left alone.
+	data, err := ioutil.ReadFile(s.knownHostsFile)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Equals, knownHosts)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestHostCertificateUnknownAuthority(c *gc.C) {
+	server, serverKey := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	serverPort := server.listener.Addr().(*net.TCPAddr).Port
+	hostCert := s.signCertificate(c, serverKey, cryptossh.HostCert, "127.0.0.1")
+	hostCertSigner, err := cryptossh.NewCertSigner(hostCert, s.testSigners["ed25519"])
+	c.Assert(err, jc.ErrorIsNil)
+	server.cfg.AddHostKey(hostCertSigner)
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+
+	var opts ssh.Options
+	opts.SetPort(serverPort)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	client, _ := newClient(c)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	_, err = cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+
+	// The authority is not trusted, so the plain
+	// host key is recorded instead.
+	knownHosts, err := ioutil.ReadFile(s.knownHostsFile)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(knownHosts), gc.Equals, fmt.Sprintf(
+		"[127.0.0.1]:%d %s",
+		serverPort,
+		cryptossh.MarshalAuthorizedKey(serverKey),
+	))
+}
+
+// hangingServer is an SSH server that accepts a command,
+// but never completes it. It replies to the first maxKeepAlives
+// keepalive requests, and ignores any after that.
+type hangingServer struct {
+	cfg           *cryptossh.ServerConfig
+	listener      net.Listener
+	maxKeepAlives int
+	keepAlives    int
+}
+
+func (s *hangingServer) run(errorCh chan error) {
+	defer close(errorCh)
+	netconn, err := s.listener.Accept()
+	if err != nil {
+		errorCh <- fmt.Errorf("accepting connection: %w", err)
+		return
+	}
+	defer netconn.Close()
+
+	_, chans, reqs, err := cryptossh.NewServerConn(netconn, s.cfg)
+	if err != nil {
+		errorCh <- fmt.Errorf("getting ssh server connection: %w", err)
+		return
+	}
+	go func() {
+		for newChannel := range chans {
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			defer channel.Close()
+			go func() {
+				for req := range reqs {
+					req.Reply(req.Type == "exec", nil)
+				}
+			}()
+		}
+	}()
+	for req := range reqs {
+		if req.Type != "keepalive@openssh.com" {
+			req.Reply(false, nil)
+			continue
+		}
+		s.keepAlives++
+		if s.keepAlives <= s.maxKeepAlives {
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *SSHGoCryptoCommandSuite) newHangingServer(c *gc.C, maxKeepAlives int) *hangingServer {
+	server := &hangingServer{
+		cfg:           &cryptossh.ServerConfig{NoClientAuth: true},
+		maxKeepAlives: maxKeepAlives,
+	}
+	server.cfg.AddHostKey(s.testSigners["ed25519"])
+	var err error
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	return server
+}
+
+func (s *SSHGoCryptoCommandSuite) TestConnectTimeout(c *gc.C) {
+	// Connections to the listener are never accepted,
+	// so the SSH handshake never completes.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	defer listener.Close()
+
+	var opts ssh.Options
+	opts.SetPort(listener.Addr().(*net.TCPAddr).Port)
+	opts.SetConnectTimeout(100 * time.Millisecond)
+	client, _ := newClient(c)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	_, err = cmd.Output()
+	c.Assert(err, gc.ErrorMatches, ".*i/o timeout")
+}
+
+func (s *SSHGoCryptoCommandSuite) TestRunContextConnecting(c *gc.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	defer listener.Close()
+
+	var opts ssh.Options
+	opts.SetPort(listener.Addr().(*net.TCPAddr).Port)
+	client, _ := newClient(c)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = cmd.RunContext(ctx)
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestHandshakeContextDone(c *gc.C) {
+	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The context is done during the handshake, so no client
+	// is returned, even if the handshake completes.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := &cryptossh.ClientConfig{
+		User: "ubuntu",
+		HostKeyCallback: func(string, net.Addr, cryptossh.PublicKey) error {
+			cancel()
+			return nil
+		},
+	}
+	client, err := ssh.SSHHandshake(ctx, conn, server.listener.Addr().String(), config)
+	c.Assert(err, gc.Equals, context.Canceled)
+	c.Assert(client, gc.IsNil)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestRunContextRunning(c *gc.C) {
+	server := s.newHangingServer(c, 1000)
+	errorCh := make(chan error, 1)
+	go server.run(errorCh)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	client, _ := newClient(c)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := cmd.RunContext(ctx)
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestServerAlive(c *gc.C) {
+	server := s.newHangingServer(c, 2)
+	errorCh := make(chan error, 1)
+	go server.run(errorCh)
+
+	var opts ssh.Options
+	opts.SetPort(server.listener.Addr().(*net.TCPAddr).Port)
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	opts.SetServerAlive(50*time.Millisecond, 3)
+	client, _ := newClient(c)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	// The server stops answering keepalives, so the
+	// client disconnects rather than waiting forever.
+	err := cmd.Run()
+	c.Assert(err, gc.NotNil)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+	c.Assert(server.keepAlives > server.maxKeepAlives, jc.IsTrue)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestKeepAlive(c *gc.C) {
+	// The server never answers keepalives.
+	server := s.newHangingServer(c, 0)
+	errorCh := make(chan error, 1)
+	go server.run(errorCh)
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	c.Assert(err, jc.ErrorIsNil)
+	config := &cryptossh.ClientConfig{
+		User:            "ubuntu",
+		HostKeyCallback: cryptossh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.SSHHandshake(context.Background(), conn, server.listener.Addr().String(), config)
+	c.Assert(err, jc.ErrorIsNil)
+	defer client.Close()
+
+	clk := testclock.NewClock(time.Now())
+	stop := make(chan struct{})
+	defer close(stop)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ssh.KeepAlive(clk, client, time.Minute, 2, stop)
+	}()
+
+	// Send the first keepalive, and let it go unanswered.
+	err = clk.WaitAdvance(time.Minute, testing.LongWait, 1)
+	c.Assert(err, jc.ErrorIsNil)
+	err = clk.WaitAdvance(time.Minute, testing.LongWait, 1)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The second keepalive is sent immediately, so there is a
+	// timer waiting for its reply. Once it too goes unanswered,
+	// the connection is closed.
+	err = clk.WaitAdvance(time.Minute, testing.LongWait, 1)
+	c.Assert(err, jc.ErrorIsNil)
+	select {
+	case <-done:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for keepalive to stop")
+	}
+	c.Assert(client.Wait(), gc.NotNil)
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+}
+
 func waitForServer(c *gc.C, errorCh chan error) error {
 	select {
 	case err, _ := <-errorCh:
@@ -279,8 +1171,20 @@ func (s *SSHGoCryptoCommandSuite) TestCommand(c *gc.C) {
 func (s *SSHGoCryptoCommandSuite) TestCopy(c *gc.C) {
 	client, err := ssh.NewGoCryptoClient()
 	c.Assert(err, jc.ErrorIsNil)
-	err = client.Copy([]string{"0.1.2.3:b", c.MkDir()}, nil)
+	var recorder auditRecorder
+	ssh.SetDefaultAuditHook(&recorder)
+	defer ssh.SetDefaultAuditHook(nil)
+	dir := c.MkDir()
+	err = client.Copy([]string{"0.1.2.3:b", dir}, nil)
 	c.Assert(err, gc.ErrorMatches, `scp command is not implemented \(OpenSSH scp not available in PATH\)`)
+
+	// The failed copy is still audited.
+	c.Assert(recorder.started, gc.HasLen, 1)
+	c.Check(recorder.started[0].Host, gc.Equals, "0.1.2.3")
+	c.Check(recorder.started[0].Command, jc.DeepEquals, []string{"scp", "0.1.2.3:b", dir})
+	c.Assert(recorder.finished, gc.HasLen, 1)
+	c.Check(recorder.finished[0].ExitCode, gc.Equals, -1)
+	c.Check(recorder.finished[0].Err, gc.Equals, err)
 }
 
 func (s *SSHGoCryptoCommandSuite) TestProxyCommand(c *gc.C) {
@@ -318,6 +1222,69 @@ func (s *SSHGoCryptoCommandSuite) TestProxyCommand(c *gc.C) {
 	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
 }
 
+func (s *SSHGoCryptoCommandSuite) TestJumpHosts(c *gc.C) {
+	client, clientKey := newClient(c)
+	server, serverKey := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
+	serverPort := server.listener.Addr().(*net.TCPAddr).Port
+	jump, jumpKey := s.newJumpServer(c)
+	jumpPort := jump.listener.Addr().(*net.TCPAddr).Port
+	var jumpUser string
+	jump.cfg.PublicKeyCallback = func(conn cryptossh.ConnMetadata, pubkey cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+		c.Check(pubkey, gc.DeepEquals, clientKey)
+		jumpUser = conn.User()
+		return nil, nil
+	}
+	errorCh := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+	go server.run(errorCh, done)
+	jumpErrorCh := make(chan error, 1)
+	go jump.run(jumpErrorCh)
+
+	var opts ssh.Options
+	opts.SetPort(serverPort)
+	opts.SetJumpHosts(fmt.Sprintf("bastion@127.0.0.1:%d", jumpPort))
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksNo)
+	cmd := client.Command("127.0.0.1", testCommand, &opts)
+	out, err := cmd.Output()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(out), gc.Equals, "abc value\n")
+	c.Assert(jumpUser, gc.Equals, "bastion")
+
+	// Both the jump host and the target host are checked, and added.
+	knownHosts, err := ioutil.ReadFile(s.knownHostsFile)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(knownHosts), gc.Equals, fmt.Sprintf(
+		"[127.0.0.1]:%d %s[127.0.0.1]:%d %s",
+		jumpPort, cryptossh.MarshalAuthorizedKey(jumpKey),
+		serverPort, cryptossh.MarshalAuthorizedKey(serverKey),
+	))
+	c.Assert(waitForServer(c, errorCh), jc.ErrorIsNil)
+	c.Assert(waitForServer(c, jumpErrorCh), jc.ErrorIsNil)
+}
+
+func (s *SSHGoCryptoCommandSuite) TestJumpHostsStrictHostChecksYes(c *gc.C) {
+	jump, _ := s.newJumpServer(c)
+	jump.cfg.NoClientAuth = true
+	jumpPort := jump.listener.Addr().(*net.TCPAddr).Port
+	jumpErrorCh := make(chan error, 1)
+	go jump.run(jumpErrorCh)
+
+	var opts ssh.Options
+	opts.SetJumpHosts(fmt.Sprintf("127.0.0.1:%d", jumpPort))
+	opts.SetStrictHostKeyChecking(ssh.StrictHostChecksYes)
+	client, _ := newClient(c)
+	cmd := client.Command("10.0.0.1", testCommand, &opts)
+	_, err := cmd.Output()
+	c.Assert(err, gc.ErrorMatches, fmt.Sprintf(
+		`connecting to jump host "127.0.0.1:%[1]d": ssh: handshake failed: no ecdsa-sha2-nistp256 host key is known for 127.0.0.1:%[1]d and you have requested strict checking`,
+		jumpPort,
+	))
+	_, err = os.Stat(s.knownHostsFile)
+	c.Assert(err, jc.Satisfies, os.IsNotExist)
+	_ = waitForServer(c, jumpErrorCh)
+}
+
 func (s *SSHGoCryptoCommandSuite) TestStrictHostChecksYes(c *gc.C) {
 	server, _ := s.newServer(c, cryptossh.ServerConfig{NoClientAuth: true})
 	serverPort := server.listener.Addr().(*net.TCPAddr).Port
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/juju/errors"
 	"github.com/juju/utils/v4"
@@ -85,7 +86,9 @@ func opensshOptions(options *Options, commandKind opensshCommandKind) []string {
 		args = append(args, "-o", "StrictHostKeyChecking "+hostChecks)
 	}
 
-	if len(options.proxyCommand) > 0 {
+	if len(options.jumpHosts) > 0 {
+		args = append(args, "-J", strings.Join(options.jumpHosts, ","))
+	} else if len(options.proxyCommand) > 0 {
 		args = append(args, "-o", "ProxyCommand "+utils.CommandString(options.proxyCommand...))
 	}
 
@@ -93,14 +96,24 @@ func opensshOptions(options *Options, commandKind opensshCommandKind) []string {
 		args = append(args, "-o", "PasswordAuthentication no")
 	}
 
-	// We must set ServerAliveInterval or the server may
-	// think we've become unresponsive on long running
-	// command executions such as "apt-get upgrade".
-	args = append(args, "-o", "ServerAliveInterval 30")
+	if options.connectTimeout > 0 {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout %d", durationSeconds(options.connectTimeout)))
+	}
+	serverAliveInterval := options.serverAliveInterval
+	if serverAliveInterval <= 0 {
+		serverAliveInterval = defaultServerAliveInterval
+	}
+	args = append(args, "-o", fmt.Sprintf("ServerAliveInterval %d", durationSeconds(serverAliveInterval)))
+	if options.serverAliveCountMax > 0 {
+		args = append(args, "-o", fmt.Sprintf("ServerAliveCountMax %d", options.serverAliveCountMax))
+	}
 
 	if options.allocatePTY {
 		args = append(args, "-t", "-t") // twice to force
 	}
+	if options.forwardAgent {
+		args = append(args, "-A")
+	}
 	if options.knownHostsFile != "" {
 		args = append(args, "-o", "UserKnownHostsFile "+utils.CommandString(options.knownHostsFile))
 	}
@@ -129,6 +142,9 @@ func opensshOptions(options *Options, commandKind opensshCommandKind) []string {
 	for _, identity := range identities {
 		args = append(args, "-i", identity)
 	}
+	for _, certificate := range options.certificates {
+		args = append(args, "-o", "CertificateFile "+utils.CommandString(certificate))
+	}
 	if options.port != 0 {
 		port := fmt.Sprint(options.port)
 		if commandKind == scpKind {
@@ -141,6 +157,12 @@ func opensshOptions(options *Options, commandKind opensshCommandKind) []string {
 	return args
 }
 
+// durationSeconds returns the duration in whole seconds, rounded
+// up, as OpenSSH options only accept times in seconds.
+func durationSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
 // Command implements Client.Command.
 func (c *OpenSSHClient) Command(host string, command []string, options *Options) *Cmd {
 	args := opensshOptions(options, sshKind)
@@ -150,7 +172,11 @@ func (c *OpenSSHClient) Command(host string, command []string, options *Options)
 	}
 	bin, args := sshpassWrap("ssh", args)
 	logger.Tracef("running: %s %s", bin, utils.CommandString(args...))
-	return &Cmd{impl: &opensshCmd{exec.Command(bin, args...)}}
+	user, hostname := splitUserHost(host)
+	return &Cmd{
+		impl:  &opensshCmd{exec.Command(bin, args...)},
+		audit: newAudit(user, hostname, command, options),
+	}
 }
 
 // Copy implements Client.Copy.
@@ -159,6 +185,7 @@ func (c *OpenSSHClient) Copy(args []string, userOptions *Options) error {
 	if userOptions != nil {
 		options = *userOptions
 		options.allocatePTY = false // doesn't make sense for scp
+		options.forwardAgent = false
 	}
 	allArgs := opensshOptions(&options, scpKind)
 	allArgs = append(allArgs, args...)
@@ -167,7 +194,11 @@ func (c *OpenSSHClient) Copy(args []string, userOptions *Options) error {
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	logger.Tracef("running: %s %s", bin, utils.CommandString(args...))
-	if err := cmd.Run(); err != nil {
+	audit := newCopyAudit(args, userOptions)
+	audit.started()
+	err := cmd.Run()
+	audit.finished(opensshExitError(err))
+	if err != nil {
 		stderr := strings.TrimSpace(stderr.String())
 		if len(stderr) > 0 {
 			err = errors.Errorf("%v (%v)", err, stderr)
@@ -209,6 +240,10 @@ func (c *opensshCmd) StderrPipe() (io.ReadCloser, io.Writer, error) {
 	return rc, c.Stderr, nil
 }
 
+func (c *opensshCmd) Wait() error {
+	return opensshExitError(c.Cmd.Wait())
+}
+
 func (c *opensshCmd) Kill() error {
 	if c.Process == nil {
 		return errors.Errorf("process has not been started")
@@ -8,11 +8,13 @@ package ssh_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
@@ -114,6 +116,25 @@ func (s *SSHCommandSuite) TestCommandEnablePTY(c *gc.C) {
 	)
 }
 
+func (s *SSHCommandSuite) TestCommandConnectTimeoutServerAlive(c *gc.C) {
+	var opts ssh.Options
+	opts.SetConnectTimeout(1500 * time.Millisecond)
+	opts.SetServerAlive(10*time.Second, 5)
+	s.assertCommandArgs(c, s.commandOptions([]string{echoCommand, "123"}, &opts),
+		fmt.Sprintf("%s -o PasswordAuthentication no -o ConnectTimeout 2 -o ServerAliveInterval 10 -o ServerAliveCountMax 5 localhost %s 123",
+			s.fakessh, echoCommand),
+	)
+}
+
+func (s *SSHCommandSuite) TestCommandEnableAgentForwarding(c *gc.C) {
+	var opts ssh.Options
+	opts.EnableAgentForwarding()
+	s.assertCommandArgs(c, s.commandOptions([]string{echoCommand, "123"}, &opts),
+		fmt.Sprintf("%s -o PasswordAuthentication no -o ServerAliveInterval 30 -A localhost %s 123",
+			s.fakessh, echoCommand),
+	)
+}
+
 func (s *SSHCommandSuite) TestCommandSetKnownHostsFile(c *gc.C) {
 	var opts ssh.Options
 	opts.SetKnownHostsFile("/tmp/known hosts")
@@ -167,6 +188,16 @@ func (s *SSHCommandSuite) TestCommandIdentities(c *gc.C) {
 	)
 }
 
+func (s *SSHCommandSuite) TestCommandCertificates(c *gc.C) {
+	var opts ssh.Options
+	opts.SetIdentities("x")
+	opts.SetCertificates("x-cert.pub")
+	s.assertCommandArgs(c, s.commandOptions([]string{echoCommand, "123"}, &opts),
+		fmt.Sprintf("%s -o PasswordAuthentication no -o ServerAliveInterval 30 -i x -o CertificateFile x-cert.pub localhost %s 123",
+			s.fakessh, echoCommand),
+	)
+}
+
 func (s *SSHCommandSuite) TestCommandPort(c *gc.C) {
 	var opts ssh.Options
 	opts.SetPort(2022)
@@ -176,6 +207,16 @@ func (s *SSHCommandSuite) TestCommandPort(c *gc.C) {
 	)
 }
 
+func (s *SSHCommandSuite) TestCommandJumpHosts(c *gc.C) {
+	var opts ssh.Options
+	opts.SetProxyCommand("nc", "%h", "%p")
+	opts.SetJumpHosts("bastion", "ubuntu@10.0.0.1:2222")
+	s.assertCommandArgs(c, s.commandOptions([]string{echoCommand, "123"}, &opts),
+		fmt.Sprintf("%s -J bastion,ubuntu@10.0.0.1:2222 -o PasswordAuthentication no -o ServerAliveInterval 30 localhost %s 123",
+			s.fakessh, echoCommand),
+	)
+}
+
 func (s *SSHCommandSuite) TestCopy(c *gc.C) {
 	var opts ssh.Options
 	opts.EnablePTY()
@@ -226,6 +267,164 @@ func (s *SSHCommandSuite) TestCommandError(c *gc.C) {
 	command := s.client.Command("ignored", []string{echoCommand, "foo"}, &opts)
 	err = command.Run()
 	c.Assert(utils.IsRcPassthroughError(err), jc.IsTrue)
+	c.Check(err.(*utils.RcPassthroughError).Code, gc.Equals, 42)
+
+	// Waiting for the command reports the status as an *ExitError.
+	command = s.client.Command("ignored", []string{echoCommand, "foo"}, &opts)
+	err = command.Start()
+	c.Assert(err, jc.ErrorIsNil)
+	err = command.Wait()
+	c.Assert(ssh.IsExitError(err), jc.IsTrue)
+	exitErr := err.(*ssh.ExitError)
+	c.Check(exitErr.ExitStatus(), gc.Equals, 42)
+	c.Check(exitErr.Signal(), gc.Equals, "")
+	c.Check(exitErr.IsConnectionError(), jc.IsFalse)
+	c.Check(err, gc.ErrorMatches, "exit status 42")
+}
+
+func (s *SSHCommandSuite) TestCommandConnectionError(c *gc.C) {
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexit 255"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	command := s.client.Command("ignored", []string{echoCommand, "foo"}, nil)
+	err = command.Start()
+	c.Assert(err, jc.ErrorIsNil)
+	err = command.Wait()
+	c.Assert(ssh.IsExitError(err), jc.IsTrue)
+	exitErr := err.(*ssh.ExitError)
+	c.Check(exitErr.ExitStatus(), gc.Equals, 255)
+	c.Check(exitErr.IsConnectionError(), jc.IsTrue)
+}
+
+func (s *SSHCommandSuite) TestRunConnectionError(c *gc.C) {
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexit 255"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	for i, run := range []func(*ssh.Cmd) error{
+		(*ssh.Cmd).Run,
+		func(cmd *ssh.Cmd) error { return cmd.RunContext(context.Background()) },
+	} {
+		c.Logf("test %d", i)
+		err = run(s.client.Command("ignored", []string{echoCommand, "foo"}, nil))
+		c.Assert(utils.IsRcPassthroughError(err), jc.IsFalse)
+		c.Assert(ssh.IsExitError(err), jc.IsTrue)
+		exitErr := err.(*ssh.ExitError)
+		c.Check(exitErr.ExitStatus(), gc.Equals, 255)
+		c.Check(exitErr.IsConnectionError(), jc.IsTrue)
+	}
+}
+
+func (s *SSHCommandSuite) TestCommandSignalled(c *gc.C) {
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nkill -TERM $$"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	command := s.client.Command("ignored", []string{echoCommand, "foo"}, nil)
+	err = command.Run()
+	c.Assert(ssh.IsExitError(err), jc.IsTrue)
+	exitErr := err.(*ssh.ExitError)
+	c.Check(exitErr.ExitStatus(), gc.Equals, -1)
+	c.Check(exitErr.Signal(), gc.Equals, "TERM")
+	c.Check(exitErr.IsConnectionError(), jc.IsFalse)
+	c.Check(err, gc.ErrorMatches, "command terminated by signal TERM")
+}
+
+func (s *SSHCommandSuite) TestRunContext(c *gc.C) {
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexit 42"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	command := s.client.Command("ignored", []string{echoCommand, "foo"}, nil)
+	err = command.RunContext(context.Background())
+	c.Assert(utils.IsRcPassthroughError(err), jc.IsTrue)
+	c.Check(err.(*utils.RcPassthroughError).Code, gc.Equals, 42)
+}
+
+func (s *SSHCommandSuite) TestRunContextCancelled(c *gc.C) {
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexec /bin/sleep 10"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	command := s.client.Command("ignored", []string{echoCommand, "foo"}, nil)
+	err = command.RunContext(ctx)
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < testing.LongWait, jc.IsTrue)
+}
+
+func (s *SSHCommandSuite) TestAuditHook(c *gc.C) {
+	var recorder auditRecorder
+	var opts ssh.Options
+	opts.SetAuditHook(&recorder)
+
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexit 42"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	command := s.client.Command("ubuntu@10.0.0.1", []string{"apt-get", "update"}, &opts)
+	err = command.Run()
+	c.Assert(utils.IsRcPassthroughError(err), jc.IsTrue)
+
+	err = ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexit 0"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	command = s.client.Command("10.0.0.2", []string{"true"}, &opts)
+	err = command.RunContext(context.Background())
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Commands run without the hook in their options are not audited.
+	command = s.client.Command("10.0.0.3", []string{"true"}, nil)
+	err = command.Run()
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Assert(recorder.started, gc.HasLen, 2)
+	c.Assert(recorder.finished, gc.HasLen, 2)
+	started := recorder.started[0]
+	c.Check(started.Host, gc.Equals, "10.0.0.1")
+	c.Check(started.User, gc.Equals, "ubuntu")
+	c.Check(started.Command, jc.DeepEquals, []string{"apt-get", "update"})
+	c.Check(started.Start.IsZero(), jc.IsFalse)
+	finished := recorder.finished[0]
+	c.Check(finished.Host, gc.Equals, "10.0.0.1")
+	c.Check(finished.Start, gc.Equals, started.Start)
+	c.Check(finished.Duration > 0, jc.IsTrue)
+	c.Check(finished.ExitCode, gc.Equals, 42)
+	c.Check(finished.Err, gc.NotNil)
+
+	c.Check(recorder.started[1].Host, gc.Equals, "10.0.0.2")
+	c.Check(recorder.started[1].User, gc.Equals, "")
+	c.Check(recorder.finished[1].ExitCode, gc.Equals, 0)
+	c.Check(recorder.finished[1].Err, jc.ErrorIsNil)
+}
+
+func (s *SSHCommandSuite) TestDefaultAuditHook(c *gc.C) {
+	var recorder, optsRecorder auditRecorder
+	ssh.SetDefaultAuditHook(&recorder)
+	s.AddCleanup(func(*gc.C) { ssh.SetDefaultAuditHook(nil) })
+
+	err := ioutil.WriteFile(s.fakessh, []byte("#!/bin/sh\nexit 0"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.client.Command("10.0.0.1", []string{"true"}, nil).Run()
+	c.Assert(err, jc.ErrorIsNil)
+
+	// A hook in the options takes precedence.
+	var opts ssh.Options
+	opts.SetAuditHook(&optsRecorder)
+	err = s.client.Command("10.0.0.2", []string{"true"}, &opts).Run()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(optsRecorder.started, gc.HasLen, 1)
+	c.Check(optsRecorder.started[0].Host, gc.Equals, "10.0.0.2")
+
+	// Copies are audited too.
+	err = s.client.Copy([]string{"-r", "./a:b", "foo@bar.com:baz"}, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ioutil.WriteFile(s.fakescp, []byte("#!/bin/sh\nexit 1"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.client.Copy([]string{"/tmp/blah", "10.0.0.3:baz"}, nil)
+	c.Assert(err, gc.NotNil)
+
+	c.Assert(recorder.started, gc.HasLen, 3)
+	c.Assert(recorder.finished, gc.HasLen, 3)
+	c.Check(recorder.started[0].Host, gc.Equals, "10.0.0.1")
+	c.Check(recorder.started[1].Host, gc.Equals, "bar.com")
+	c.Check(recorder.started[1].User, gc.Equals, "foo")
+	c.Check(recorder.started[1].Command, jc.DeepEquals, []string{"scp", "-r", "./a:b", "foo@bar.com:baz"})
+	c.Check(recorder.finished[1].ExitCode, gc.Equals, 0)
+	c.Check(recorder.finished[1].Err, jc.ErrorIsNil)
+	c.Check(recorder.started[2].Host, gc.Equals, "10.0.0.3")
+	c.Check(recorder.finished[2].ExitCode, gc.Equals, 1)
+	c.Check(recorder.finished[2].Err, gc.NotNil)
 }
 
 func (s *SSHCommandSuite) TestCommandDefaultIdentities(c *gc.C) {
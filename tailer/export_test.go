@@ -4,6 +4,7 @@
 package tailer
 
 var (
-	BufferSize    = &bufferSize
-	NewTestTailer = newTailer
+	BufferSize        = &bufferSize
+	NewTestTailer     = newTailer
+	NewTestFileTailer = newFileTailer
 )
@@ -0,0 +1,164 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tailer
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// FileTailerConfig holds the parameters for NewFileTailer.
+type FileTailerConfig struct {
+	// Path is the path of the file to follow.
+	Path string
+
+	// Writer receives the tailed lines.
+	Writer io.Writer
+
+	// Filter, if not nil, decides which lines are tailed.
+	Filter TailerFilterFunc
+
+	// Lines is the number of lines before the end of the
+	// file that are tailed first. If it is zero, only lines
+	// written after the tailer has started are tailed.
+	Lines uint
+
+	// DrainRotated, if true, tails any lines remaining in a file
+	// once it has been rotated before following the new file at
+	// Path, as "tail -F" does. Otherwise the new file is followed
+	// as soon as the rotation is noticed.
+	DrainRotated bool
+}
+
+// Validate returns an error if the config cannot be used to tail a file.
+func (config FileTailerConfig) Validate() error {
+	if config.Path == "" {
+		return errors.NotValidf("empty Path")
+	}
+	if config.Writer == nil {
+		return errors.NotValidf("nil Writer")
+	}
+	return nil
+}
+
+// follower holds the state of a Tailer following a file by path.
+type follower struct {
+	path  string
+	drain bool
+	file  *os.File
+	info  os.FileInfo
+}
+
+// NewFileTailer starts a Tailer which follows the file at the configured
+// path, like "tail -F". When the file is rotated, by being renamed or
+// removed and recreated, the Tailer reopens the path and tails the new
+// file from its beginning. The same happens when the file is truncated,
+// as logrotate's copytruncate does.
+func NewFileTailer(config FileTailerConfig) (*Tailer, error) {
+	return newFileTailer(config, polltime)
+}
+
+// newFileTailer starts a Tailer like NewFileTailer but allows the
+// setting of the time between pollings for testing.
+func newFileTailer(config FileTailerConfig, polltime time.Duration) (*Tailer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	file, err := os.Open(config.Path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info, err := file.Stat()
+	if err == nil {
+		err = SeekLastLines(file, config.Lines, config.Filter)
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.Trace(err)
+	}
+	t := &Tailer{
+		readSeeker: file,
+		reader:     bufio.NewReaderSize(file, bufferSize),
+		writer:     bufio.NewWriter(config.Writer),
+		filter:     config.Filter,
+		polltime:   polltime,
+		follow: &follower{
+			path:  config.Path,
+			drain: config.DrainRotated,
+			file:  file,
+			info:  info,
+		},
+	}
+	go func() {
+		defer t.tomb.Done()
+		defer func() {
+			t.follow.file.Close()
+		}()
+		t.tomb.Kill(t.loop())
+	}()
+	return t, nil
+}
+
+// followFile checks whether the followed file has been rotated or
+// truncated, and if so arranges for tailing to carry on from the
+// beginning of its new content.
+func (t *Tailer) followFile() error {
+	info, err := os.Stat(t.follow.path)
+	if os.IsNotExist(err) {
+		// The file has been moved away but not yet recreated,
+		// so carry on with the old one in the meantime.
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !os.SameFile(info, t.follow.info) {
+		if t.follow.drain {
+			if err := t.readLines(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return errors.Trace(t.reopen())
+	}
+	offset, err := t.follow.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	offset -= int64(t.reader.Buffered())
+	if info.Size() < offset {
+		if _, err := t.follow.file.Seek(0, io.SeekStart); err != nil {
+			return errors.Trace(err)
+		}
+		t.reader.Reset(t.follow.file)
+	}
+	return nil
+}
+
+// reopen replaces the followed file with the one now at its path.
+func (t *Tailer) reopen() error {
+	file, err := os.Open(t.follow.path)
+	if os.IsNotExist(err) {
+		// The file has been moved away again; try
+		// once more when next polling.
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	t.follow.file.Close()
+	t.follow.file = file
+	t.follow.info = info
+	t.readSeeker = file
+	t.reader.Reset(file)
+	return nil
+}
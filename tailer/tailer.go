@@ -38,6 +38,10 @@ type Tailer struct {
 	writer      *bufio.Writer
 	filter      TailerFilterFunc
 	polltime    time.Duration
+
+	// follow is set when the Tailer follows a file
+	// by path, so that it notices rotation.
+	follow *follower
 }
 
 // NewTailer starts a Tailer which reads strings from the passed
@@ -93,29 +97,22 @@ func (t *Tailer) Err() error {
 // writer and then polls for more data to write it to the
 // writer too.
 func (t *Tailer) loop() error {
-	// Start polling.
-	// TODO(mue) 2013-12-06
-	// Handling of read-seeker/files being truncated during
-	// tailing is currently missing!
+	// Start polling. Truncation and rotation are only handled
+	// when following a file by path, see NewFileTailer.
 	timer := time.NewTimer(0)
 	for {
 		select {
 		case <-t.tomb.Dying():
 			return nil
 		case <-timer.C:
-			for {
-				line, readErr := t.readLine()
-				_, writeErr := t.writer.Write(line)
-				if writeErr != nil {
-					return writeErr
-				}
-				if readErr != nil {
-					if readErr != io.EOF {
-						return readErr
-					}
-					break
+			if t.follow != nil {
+				if err := t.followFile(); err != nil {
+					return err
 				}
 			}
+			if err := t.readLines(); err != nil {
+				return err
+			}
 			if writeErr := t.writer.Flush(); writeErr != nil {
 				return writeErr
 			}
@@ -124,6 +121,24 @@ func (t *Tailer) loop() error {
 	}
 }
 
+// readLines writes all the complete lines
+// that can be read to the writer.
+func (t *Tailer) readLines() error {
+	for {
+		line, readErr := t.readLine()
+		_, writeErr := t.writer.Write(line)
+		if writeErr != nil {
+			return writeErr
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				return readErr
+			}
+			return nil
+		}
+	}
+}
+
 // SeekLastLines sets the read position of the ReadSeeker to the
 // wanted number of filtered lines before the end.
 func SeekLastLines(readSeeker io.ReadSeeker, lines uint, filter TailerFilterFunc) error {
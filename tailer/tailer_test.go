@@ -8,10 +8,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/juju/errors"
 	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
 	"github.com/juju/utils/v4/tailer"
@@ -364,6 +368,112 @@ func (s *tailerSuite) TestTailer(c *gc.C) {
 	}
 }
 
+func (s *tailerSuite) TestFileTailerConfigValidate(c *gc.C) {
+	_, err := tailer.NewFileTailer(tailer.FileTailerConfig{Writer: &bytes.Buffer{}})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, "empty Path not valid")
+	_, err = tailer.NewFileTailer(tailer.FileTailerConfig{Path: "/some/file"})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, "nil Writer not valid")
+}
+
+func (s *tailerSuite) TestFileTailerNotFound(c *gc.C) {
+	_, err := tailer.NewFileTailer(tailer.FileTailerConfig{
+		Path:   filepath.Join(c.MkDir(), "missing.log"),
+		Writer: &bytes.Buffer{},
+	})
+	c.Assert(errors.Is(err, os.ErrNotExist), jc.IsTrue)
+}
+
+func (s *tailerSuite) TestFileTailerTruncated(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "test.log")
+	writeFile(c, path, "one\ntwo\n")
+	linec, t := startFileTailer(c, tailer.FileTailerConfig{Path: path, Lines: 1})
+	assertCollected(c, linec, []string{"two\n"}, nil)
+
+	appendFile(c, path, "three\n")
+	assertCollected(c, linec, []string{"three\n"}, nil)
+
+	// Truncate the file, as copytruncate does.
+	writeFile(c, path, "four\n")
+	assertCollected(c, linec, []string{"four\n"}, nil)
+	c.Assert(t.Stop(), jc.ErrorIsNil)
+}
+
+func (s *tailerSuite) TestFileTailerRotated(c *gc.C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "test.log")
+	writeFile(c, path, "one\n")
+	linec, t := startFileTailer(c, tailer.FileTailerConfig{Path: path, Lines: 1})
+	assertCollected(c, linec, []string{"one\n"}, nil)
+
+	// Replace the file, then write to the old one, which is
+	// then never tailed.
+	old, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, jc.ErrorIsNil)
+	defer old.Close()
+	replaceFile(c, path, "two\n")
+	_, err = old.WriteString("lost\n")
+	c.Assert(err, jc.ErrorIsNil)
+	assertCollected(c, linec, []string{"two\n"}, nil)
+
+	// Remove the file, and create it again.
+	err = os.Remove(path)
+	c.Assert(err, jc.ErrorIsNil)
+	writeFile(c, path, "three\n")
+	assertCollected(c, linec, []string{"three\n"}, nil)
+	c.Assert(t.Stop(), jc.ErrorIsNil)
+}
+
+func (s *tailerSuite) TestFileTailerDrainRotated(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "test.log")
+	writeFile(c, path, "one\n")
+	linec, t := startFileTailer(c, tailer.FileTailerConfig{
+		Path:         path,
+		Lines:        1,
+		DrainRotated: true,
+	})
+	assertCollected(c, linec, []string{"one\n"}, nil)
+
+	// Lines written to the old file before it is replaced are
+	// tailed before those in the new file.
+	appendFile(c, path, "two\n")
+	replaceFile(c, path, "three\n")
+	assertCollected(c, linec, []string{"two\n", "three\n"}, nil)
+	c.Assert(t.Stop(), jc.ErrorIsNil)
+}
+
+// startFileTailer starts a Tailer following a file and
+// returns a channel receiving the tailed lines.
+func startFileTailer(c *gc.C, config tailer.FileTailerConfig) (chan string, *tailer.Tailer) {
+	reader, writer := io.Pipe()
+	config.Writer = writer
+	t, err := tailer.NewTestFileTailer(config, 2*time.Millisecond)
+	c.Assert(err, jc.ErrorIsNil)
+	return startReading(c, t, reader, writer), t
+}
+
+func writeFile(c *gc.C, path, data string) {
+	err := os.WriteFile(path, []byte(data), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func appendFile(c *gc.C, path, data string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, jc.ErrorIsNil)
+	defer f.Close()
+	_, err = f.WriteString(data)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+// replaceFile atomically replaces the file at path
+// with a new one holding data.
+func replaceFile(c *gc.C, path, data string) {
+	writeFile(c, path+".new", data)
+	err := os.Rename(path+".new", path)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
 // startReading starts a goroutine receiving the lines out of the reader
 // in the background and passing them to a created string channel. This
 // will used in the assertions.
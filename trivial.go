@@ -30,9 +30,18 @@ func WinPSQuote(s string) string {
 	// Double quotes inside single quotes are fine, double single quotes inside
 	// single quotes, not so much so. Having double quoted strings inside single
 	// quoted strings, ensure no expansion happens.
-	return `'` + strings.Replace(s, `'`, `"`, -1) + `'`
+	// PowerShell also treats typographic single quotes as quotes.
+	return `'` + winPSQuoteReplacer.Replace(s) + `'`
 }
 
+var winPSQuoteReplacer = strings.NewReplacer(
+	`'`, `"`,
+	"\u2018", `"`,
+	"\u2019", `"`,
+	"\u201a", `"`,
+	"\u201b", `"`,
+)
+
 // WinCmdQuote quotes s so that when read by cmd.exe, no metacharacters
 // within s will be interpreted as such.
 func WinCmdQuote(s string) string {
@@ -43,13 +52,23 @@ func WinCmdQuote(s string) string {
 
 func winCmdQuote(s string) string {
 	var escaped string
+	backslashes := 0
 	for _, c := range s {
 		switch c {
-		case '\\', '"':
-			escaped += `\`
+		case '\\':
+			backslashes++
+		case '"':
+			// Backslashes are only special before a quote, so
+			// they must be escaped along with the quote.
+			escaped += strings.Repeat(`\`, backslashes+1)
+			backslashes = 0
+		default:
+			backslashes = 0
 		}
 		escaped += string(c)
 	}
+	// Likewise for backslashes before the closing quote.
+	escaped += strings.Repeat(`\`, backslashes)
 	return `"` + escaped + `"`
 }
 
@@ -64,6 +64,9 @@ func (*utilsSuite) TestWinCmdQuote(c *gc.C) {
 		`a"`:               `^"a\^"^"`,
 		`"a"`:              `^"\^"a\^"^"`,
 		"abc > xyz 2>&1 &": `^"abc ^> xyz 2^>^&1 ^&^"`,
+		`C:\a\b`:           `^"C:\a\b^"`,
+		`\\server\share\`:  `^"\\server\share\\^"`,
+		`a\"b`:             `^"a\\\^"b^"`,
 	}
 	checkQuoting(c, utils.WinCmdQuote, args)
 }
@@ -77,6 +80,7 @@ func (*utilsSuite) TestWinPSQuote(c *gc.C) {
 		"a'":               `'a"'`,
 		"'a'":              `'"a"'`,
 		"abc > xyz 2>&1 &": "'abc > xyz 2>&1 &'",
+		"it\u2019s":        `'it"s'`,
 	}
 	checkQuoting(c, utils.WinPSQuote, args)
 }